@@ -33,6 +33,42 @@ type ServerOption struct {
 	PodInitialBackoffSeconds int
 	// Pod in the backoffQ max duration
 	PodMaxBackoffSeconds int
+	// Interval to retry the units staying in the unschedulableQ for too long
+	UnschedulableQFlushSeconds int
+	// Pod in the unschedulableQ max duration without a relevant event
+	PodMaxInUnschedulableQSeconds int
+	// Max number of dequeued units per queue, 0 means unlimited
+	MaxDequeuedUnitsPerQueue int
+	// Max number of dequeued units per user in a queue, 0 means unlimited
+	MaxDequeuedUnitsPerUser int
+	// JSON file of the quotas of the queues keyed by namespace/name, empty for none
+	QueueQuotaFile string
+	// Whether the units whose requests exceed the capacity left in the cluster are held
+	ClusterCapacity bool
+	// Label of QueueUnit identifying its submitter
+	UserLabel string
+	// Priority gained by a unit for each minute it waits in an aging queue
+	AgingRate float64
+	// Max priority a unit can gain by waiting in an aging queue, 0 means unlimited
+	AgingCap int64
+	// Interval to re-sort the aging queues
+	AgingRefreshSeconds int
+	// Name of the queue of the units without spec.queue
+	DefaultQueueName string
+	// Queue of the units whose queue does not exist, namespace/name
+	FallbackQueue string
+	// What happens to the pending units of a deleted Queue, Block or Move
+	QueueDeletionPolicy string
+	// Whether the consumers of the dequeued units are watched to release their reservations
+	TrackConsumers bool
+	// How long the consumer of a dequeued unit has to start, 0 means no deadline
+	StartDeadlineSeconds int
+	// Number of start deadline expiries after which a unit fails, 0 means never
+	MaxStartDeadlineExpiries int
+	// Number of quota scopes scheduled concurrently
+	Parallelism int
+	// Address to serve the metrics on, empty to disable
+	MetricsAddress string
 }
 
 func NewServerOption() *ServerOption {
@@ -46,4 +82,22 @@ func (s *ServerOption) AddFlags(fs *flag.FlagSet) {
 	fs.IntVar(&s.Burst, "burst", 10, "Maximum burst for throttle.")
 	fs.IntVar(&s.PodInitialBackoffSeconds, "podInitialBackoffSeconds", 1, "Pod in the backoffQ init duration")
 	fs.IntVar(&s.PodMaxBackoffSeconds, "podMaxBackoffSeconds", 20, "Pod in the backoffQ max duration")
+	fs.IntVar(&s.UnschedulableQFlushSeconds, "unschedulableQFlushSeconds", 30, "Interval to retry the units staying in the unschedulableQ for longer than podMaxInUnschedulableQSeconds")
+	fs.IntVar(&s.PodMaxInUnschedulableQSeconds, "podMaxInUnschedulableQSeconds", 300, "Pod in the unschedulableQ max duration if no relevant event moves it earlier")
+	fs.IntVar(&s.MaxDequeuedUnitsPerQueue, "maxDequeuedUnitsPerQueue", 0, "Max number of dequeued units per queue, 0 means unlimited")
+	fs.IntVar(&s.MaxDequeuedUnitsPerUser, "maxDequeuedUnitsPerUser", 0, "Max number of dequeued units per user in a queue, 0 means unlimited")
+	fs.StringVar(&s.QueueQuotaFile, "queueQuotaFile", "", "JSON file of the quotas of the queues keyed by namespace/name, e.g. a mounted ConfigMap. The quota annotation of a Queue takes precedence")
+	fs.BoolVar(&s.ClusterCapacity, "clusterCapacity", false, "Hold the units whose requests exceed the capacity left in the schedulable nodes, the nodes are watched only if enabled")
+	fs.StringVar(&s.UserLabel, "userLabel", "", "Label of QueueUnit identifying its submitter")
+	fs.Float64Var(&s.AgingRate, "agingRate", 1, "Priority gained by a unit for each minute it waits in an aging queue")
+	fs.Int64Var(&s.AgingCap, "agingCap", 0, "Max priority a unit can gain by waiting in an aging queue, 0 means unlimited")
+	fs.IntVar(&s.AgingRefreshSeconds, "agingRefreshSeconds", 30, "Interval to re-sort the aging queues")
+	fs.StringVar(&s.DefaultQueueName, "defaultQueueName", "default", "Name of the queue in their namespace of the units without spec.queue")
+	fs.StringVar(&s.FallbackQueue, "fallbackQueue", "", "Queue of the units whose queue does not exist, namespace/name. Such units are parked until their queue is added if empty")
+	fs.StringVar(&s.QueueDeletionPolicy, "queueDeletionPolicy", "Move", "What happens to the pending units of a deleted Queue, Block blocks the deletion until they are gone, Move moves them to the fallback queue")
+	fs.BoolVar(&s.TrackConsumers, "trackConsumers", false, "Watch the consumers of the dequeued units, and release their reservations when they complete, fail or are deleted. Needs the permission to list and watch the kinds of consumer")
+	fs.IntVar(&s.StartDeadlineSeconds, "startDeadlineSeconds", 0, "Seconds the consumer of a dequeued unit has to start a pod before the unit is requeued, 0 means no deadline")
+	fs.IntVar(&s.MaxStartDeadlineExpiries, "maxStartDeadlineExpiries", 3, "Number of start deadline expiries after which a unit fails instead of being requeued, 0 means never")
+	fs.IntVar(&s.Parallelism, "parallelism", 1, "Number of quota scopes scheduled concurrently, the queues of a namespace are always scheduled in order")
+	fs.StringVar(&s.MetricsAddress, "metricsAddress", ":8080", "Address to serve the metrics on at /debug/vars, empty to disable")
 }
@@ -18,16 +18,30 @@ package app
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/kube-queue/api/pkg/client/clientset/versioned"
 	externalversions "github.com/kube-queue/api/pkg/client/informers/externalversions"
 	"github.com/kube-queue/kube-queue/cmd/app/options"
 	"github.com/kube-queue/kube-queue/pkg/controller"
-
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/aging"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/clustercapacity"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/concurrency"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/queuequota"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/userfairness"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 )
@@ -67,13 +81,75 @@ func Run(opt *options.ServerOption) error {
 		return err
 	}
 
+	var dynamicClient dynamic.Interface
+	var restMapper meta.RESTMapper
+	if opt.TrackConsumers {
+		dynamicClient, err = dynamic.NewForConfig(restConfig)
+		if err != nil {
+			return err
+		}
+		restMapper = restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(kubeClient.Discovery()))
+	}
+
 	queueUnitInformerFactory := externalversions.NewSharedInformerFactory(queueUnitClient, 0)
 	queueUnitInformer := queueUnitInformerFactory.Scheduling().V1alpha1().QueueUnits().Informer()
 	queueInformer := queueUnitInformerFactory.Scheduling().V1alpha1().Queues().Informer()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		<-sigCh
+		klog.Infof("Received termination signal, shutting down")
+		cancel()
+	}()
+
+	if opt.MetricsAddress != "" {
+		go func() {
+			// expvar serves the metrics at /debug/vars of the default mux
+			if err := http.ListenAndServe(opt.MetricsAddress, nil); err != nil {
+				klog.Errorf("Error serving metrics: %s", err.Error())
+			}
+		}()
+	}
+
+	queueQuotaArgs := &queuequota.Args{}
+	if opt.QueueQuotaFile != "" {
+		queueQuotaArgs.Limits, err = queuequota.ReadLimitsFile(opt.QueueQuotaFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	pluginArgs := map[string]runtime.Object{
+		concurrency.Name: &concurrency.Args{
+			MaxDequeuedUnits:        opt.MaxDequeuedUnitsPerQueue,
+			MaxDequeuedUnitsPerUser: opt.MaxDequeuedUnitsPerUser,
+			UserLabel:               opt.UserLabel,
+		},
+		userfairness.Name: &userfairness.Args{
+			UserLabel: opt.UserLabel,
+		},
+		aging.Name: &aging.Args{
+			Rate:            opt.AgingRate,
+			Cap:             opt.AgingCap,
+			RefreshInterval: time.Duration(opt.AgingRefreshSeconds) * time.Second,
+		},
+		queuequota.Name: queueQuotaArgs,
+		clustercapacity.Name: &clustercapacity.Args{
+			Disabled: !opt.ClusterCapacity,
+		},
+	}
+
+	policy := controller.QueueDeletionPolicy(opt.QueueDeletionPolicy)
+	if policy != controller.QueueDeletionBlock && policy != controller.QueueDeletionMove {
+		return fmt.Errorf("unknown queue deletion policy %q", opt.QueueDeletionPolicy)
+	}
+	if opt.UnschedulableQFlushSeconds <= 0 {
+		return fmt.Errorf("unschedulableQFlushSeconds must be positive, got %d", opt.UnschedulableQFlushSeconds)
+	}
 
-	controller, err := controller.NewController(kubeClient, opt.KubeConfig, kubeInformerFactory, queueUnitClient, queueUnitInformer, queueInformer, ctx.Done(), opt.PodInitialBackoffSeconds, opt.PodMaxBackoffSeconds)
+	controller, err := controller.NewController(kubeClient, opt.KubeConfig, kubeInformerFactory, queueUnitClient, queueUnitInformerFactory, queueUnitInformer, queueInformer, dynamicClient, restMapper, ctx.Done(), opt.PodInitialBackoffSeconds, opt.PodMaxBackoffSeconds, opt.UnschedulableQFlushSeconds, opt.PodMaxInUnschedulableQSeconds, opt.Parallelism, opt.DefaultQueueName, opt.FallbackQueue, policy, time.Duration(opt.StartDeadlineSeconds)*time.Second, opt.MaxStartDeadlineExpiries, pluginArgs)
 	if err != nil {
 		klog.Fatalln("Error building controller\n")
 	}
@@ -4,6 +4,7 @@ go 1.14
 
 require (
 	github.com/kube-queue/api v0.0.0-20220112140309-2f9d9676d6de
+	github.com/robfig/cron/v3 v3.0.1
 	gopkg.in/yaml.v2 v2.3.0 // indirect
 	k8s.io/api v0.18.19
 	k8s.io/apimachinery v0.18.19
@@ -0,0 +1,231 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+// consumerTracker watches the consumers referred by the ConsumerRef of the admitted
+// QueueUnits, with a dynamic informer per resource, and reports the consumers which
+// complete, fail or disappear.
+type consumerTracker struct {
+	sync.Mutex
+	mapper  meta.RESTMapper
+	factory dynamicinformer.DynamicSharedInformerFactory
+	stopCh  <-chan struct{}
+	// onFinished is called with the key of the unit and the terminal phase when its
+	// consumer finishes
+	onFinished func(key string, phase v1alpha1.QueueUnitPhase, message string)
+	// informers are the informers started by resource
+	informers map[schema.GroupVersionResource]cache.SharedIndexInformer
+	// units are the keys of the tracked units by consumer key
+	units map[string]string
+}
+
+func newConsumerTracker(client dynamic.Interface, mapper meta.RESTMapper, stopCh <-chan struct{},
+	onFinished func(string, v1alpha1.QueueUnitPhase, string)) *consumerTracker {
+	return &consumerTracker{
+		mapper:     mapper,
+		factory:    dynamicinformer.NewDynamicSharedInformerFactory(client, 0),
+		stopCh:     stopCh,
+		onFinished: onFinished,
+		informers:  make(map[schema.GroupVersionResource]cache.SharedIndexInformer),
+		units:      make(map[string]string),
+	}
+}
+
+// track starts watching the consumer of the unit, it is a no-op without tracker or
+// ConsumerRef
+func (t *consumerTracker) track(unit *v1alpha1.QueueUnit) {
+	if t == nil || unit.Spec.ConsumerRef == nil {
+		return
+	}
+	ref := unit.Spec.ConsumerRef
+	gvr, err := t.resourceOf(ref.APIVersion, ref.Kind)
+	if err != nil {
+		klog.Errorf("cannot track consumer %s %s/%s of unit %s/%s: %v", ref.Kind, ref.Namespace, ref.Name, unit.Namespace, unit.Name, err)
+		return
+	}
+
+	t.Lock()
+	key := consumerKey(gvr, utils.QueueUnitNamespace(unit), ref.Name)
+	t.units[key] = unit.Namespace + "/" + unit.Name
+	informer := t.informerFor(gvr)
+	t.Unlock()
+
+	// the consumer may have finished while the unit was not tracked, e.g. during a restart
+	if informer.HasSynced() {
+		obj, exists, err := informer.GetStore().GetByKey(utils.QueueUnitNamespace(unit) + "/" + ref.Name)
+		if err == nil && exists {
+			t.check(gvr, obj)
+		}
+	}
+}
+
+// untrack stops watching the consumer of the unit
+func (t *consumerTracker) untrack(unit *v1alpha1.QueueUnit) {
+	if t == nil || unit.Spec.ConsumerRef == nil {
+		return
+	}
+	ref := unit.Spec.ConsumerRef
+	gvr, err := t.resourceOf(ref.APIVersion, ref.Kind)
+	if err != nil {
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	delete(t.units, consumerKey(gvr, utils.QueueUnitNamespace(unit), ref.Name))
+}
+
+func (t *consumerTracker) resourceOf(apiVersion, kind string) (schema.GroupVersionResource, error) {
+	gvk := schema.FromAPIVersionAndKind(apiVersion, kind)
+	mapping, err := t.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return schema.GroupVersionResource{}, err
+	}
+	return mapping.Resource, nil
+}
+
+// informerFor returns the informer of the resource, starting it on first use. The
+// caller must hold the lock.
+func (t *consumerTracker) informerFor(gvr schema.GroupVersionResource) cache.SharedIndexInformer {
+	if informer, ok := t.informers[gvr]; ok {
+		return informer
+	}
+	klog.Infof("start watching consumers %s", gvr.String())
+	informer := t.factory.ForResource(gvr).Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			t.check(gvr, obj)
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			t.check(gvr, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if consumer, ok := obj.(*unstructured.Unstructured); ok {
+				t.finish(gvr, consumer, v1alpha1.SchedFailed, "consumer is deleted")
+			}
+		},
+	})
+	t.informers[gvr] = informer
+	t.factory.Start(t.stopCh)
+	return informer
+}
+
+// check reports the consumer if it is finished
+func (t *consumerTracker) check(gvr schema.GroupVersionResource, obj interface{}) {
+	consumer, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	if phase, message, finished := consumerFinished(consumer); finished {
+		t.finish(gvr, consumer, phase, message)
+	}
+}
+
+func (t *consumerTracker) finish(gvr schema.GroupVersionResource, consumer *unstructured.Unstructured, phase v1alpha1.QueueUnitPhase, message string) {
+	t.Lock()
+	key := consumerKey(gvr, consumer.GetNamespace(), consumer.GetName())
+	unitKey, ok := t.units[key]
+	delete(t.units, key)
+	t.Unlock()
+
+	if ok {
+		t.onFinished(unitKey, phase, message)
+	}
+}
+
+func consumerKey(gvr schema.GroupVersionResource, namespace, name string) string {
+	return gvr.String() + "/" + namespace + "/" + name
+}
+
+// consumerFinished returns the terminal phase of a finished consumer. A consumer is
+// finished when its status.phase is Succeeded or Failed, like Pods, or when it has a
+// true condition of type Succeeded, Complete or Failed, like Jobs and Kubeflow jobs.
+func consumerFinished(consumer *unstructured.Unstructured) (v1alpha1.QueueUnitPhase, string, bool) {
+	phase, _, _ := unstructured.NestedString(consumer.Object, "status", "phase")
+	switch phase {
+	case "Succeeded":
+		return v1alpha1.SchedSucceed, "consumer succeeded", true
+	case "Failed":
+		return v1alpha1.SchedFailed, "consumer failed", true
+	}
+
+	conditions, _, _ := unstructured.NestedSlice(consumer.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["status"] != "True" {
+			continue
+		}
+		switch condition["type"] {
+		case "Succeeded", "Complete":
+			return v1alpha1.SchedSucceed, fmt.Sprintf("consumer succeeded: %v", condition["message"]), true
+		case "Failed":
+			return v1alpha1.SchedFailed, fmt.Sprintf("consumer failed: %v", condition["message"]), true
+		}
+	}
+	return "", "", false
+}
+
+// finishQueueUnit moves the admitted unit whose consumer finished to the terminal
+// phase, and releases its reservations
+func (c *Controller) finishQueueUnit(key string, phase v1alpha1.QueueUnitPhase, message string) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return
+	}
+	var unit *v1alpha1.QueueUnit
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		unit, err = c.queueUnitClient.SchedulingV1alpha1().QueueUnits(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil || !utils.IsAdmitted(unit) {
+			return err
+		}
+		unit.Status.Phase = phase
+		unit.Status.Message = message
+		_, err = c.queueUnitClient.SchedulingV1alpha1().QueueUnits(namespace).Update(context.TODO(), unit, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		klog.Errorf("finish unit %s fail %v", key, err)
+		return
+	}
+	if unit.Status.Phase != phase {
+		return
+	}
+	klog.Infof("unit %s finished in phase %s: %s", key, phase, message)
+	c.unreserve(unit)
+}
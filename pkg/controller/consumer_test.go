@@ -0,0 +1,60 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+)
+
+func TestConsumerFinished(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   map[string]interface{}
+		phase    v1alpha1.QueueUnitPhase
+		finished bool
+	}{
+		{"no status", nil, "", false},
+		{"running pod", map[string]interface{}{"phase": "Running"}, "", false},
+		{"succeeded pod", map[string]interface{}{"phase": "Succeeded"}, v1alpha1.SchedSucceed, true},
+		{"failed pod", map[string]interface{}{"phase": "Failed"}, v1alpha1.SchedFailed, true},
+		{"complete job", map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "Complete", "status": "True"},
+		}}, v1alpha1.SchedSucceed, true},
+		{"failed tfjob", map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "Running", "status": "False"},
+			map[string]interface{}{"type": "Failed", "status": "True"},
+		}}, v1alpha1.SchedFailed, true},
+		{"running tfjob", map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "Running", "status": "True"},
+			map[string]interface{}{"type": "Succeeded", "status": "False"},
+		}}, "", false},
+	}
+	for _, tt := range tests {
+		consumer := &unstructured.Unstructured{Object: map[string]interface{}{}}
+		if tt.status != nil {
+			consumer.Object["status"] = tt.status
+		}
+		phase, _, finished := consumerFinished(consumer)
+		if phase != tt.phase || finished != tt.finished {
+			t.Errorf("%s: expected %q %v, got %q %v", tt.name, tt.phase, tt.finished, phase, finished)
+		}
+	}
+}
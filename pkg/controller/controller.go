@@ -18,17 +18,28 @@ package controller
 
 import (
 	"context"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 
 	"github.com/kube-queue/api/pkg/client/clientset/versioned"
+	queuescheme "github.com/kube-queue/api/pkg/client/clientset/versioned/scheme"
+	"github.com/kube-queue/api/pkg/client/informers/externalversions"
+	listers "github.com/kube-queue/api/pkg/client/listers/scheduling/v1alpha1"
 	"github.com/kube-queue/kube-queue/pkg/framework"
 	"github.com/kube-queue/kube-queue/pkg/framework/plugins"
 	"github.com/kube-queue/kube-queue/pkg/framework/runtime"
@@ -48,6 +59,18 @@ type Controller struct {
 	queueUnitInformer    cache.SharedIndexInformer
 	queueUnitClient      *versioned.Clientset
 	queueInformer        cache.SharedIndexInformer
+	queueLister          listers.QueueLister
+	queueUnitLister      listers.QueueUnitLister
+	// consumers tracks the consumers of the admitted units, nil if disabled
+	consumers           *consumerTracker
+	queueDeletionPolicy QueueDeletionPolicy
+	// startDeadline is how long the consumer of a dequeued unit has to start, 0 if
+	// there is no deadline
+	startDeadline            time.Duration
+	maxStartDeadlineExpiries int
+	podLister                corelisters.PodLister
+	// cacheSyncs are the informers to be synced before scheduling
+	cacheSyncs []cache.InformerSynced
 }
 
 func NewController(
@@ -55,44 +78,85 @@ func NewController(
 	kubeConfigPath string,
 	informersFactory informers.SharedInformerFactory,
 	queueUnitClient *versioned.Clientset,
+	queueInformerFactory externalversions.SharedInformerFactory,
 	queueUnitInformer cache.SharedIndexInformer,
 	queueInformer cache.SharedIndexInformer,
+	dynamicClient dynamic.Interface,
+	restMapper meta.RESTMapper,
 	stopCh <-chan struct{},
 	podInitialBackoffSeconds int,
-	podMaxBackoffSeconds int) (*Controller, error) {
+	podMaxBackoffSeconds int,
+	unschedulableQFlushSeconds int,
+	podMaxInUnschedulableQSeconds int,
+	parallelism int,
+	defaultQueueName string,
+	fallbackQueue string,
+	queueDeletionPolicy QueueDeletionPolicy,
+	startDeadline time.Duration,
+	maxStartDeadlineExpiries int,
+	pluginArgs map[string]k8sruntime.Object) (*Controller, error) {
 
 	// Create event broadcaster
 	eventBroadcaster := record.NewBroadcaster()
 	eventBroadcaster.StartLogging(klog.Infof)
 	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
 
+	// the Events are also recorded on the Queues
 	schemeModified := scheme.Scheme
+	utilruntime.Must(queuescheme.AddToScheme(schemeModified))
 	recorder := eventBroadcaster.NewRecorder(schemeModified, corev1.EventSource{Component: utils.ControllerAgentName})
 
 	r := plugins.NewInTreeRegistry()
-	fw, err := runtime.NewFramework(r, kubeConfigPath, informersFactory, queueUnitClient)
+	fw, err := runtime.NewFramework(r, pluginArgs, kubeConfigPath, informersFactory, queueInformerFactory, queueUnitClient)
 	if err != nil {
 		klog.Fatalf("new framework failed %v", err)
 	}
 
-	multiSchedulingQueue, err := multischedulingqueue.NewMultiSchedulingQueue(fw, podInitialBackoffSeconds, podMaxBackoffSeconds)
+	multiSchedulingQueue, err := multischedulingqueue.NewMultiSchedulingQueue(fw, podInitialBackoffSeconds, podMaxBackoffSeconds, unschedulableQFlushSeconds, podMaxInUnschedulableQSeconds, defaultQueueName, fallbackQueue)
 	if err != nil {
 		klog.Fatalf("init multi scheduling queue failed %s", err)
 	}
 
 	controller := &Controller{
-		recorder:             recorder,
-		fw:                   fw,
-		multiSchedulingQueue: multiSchedulingQueue,
-		queueUnitClient:      queueUnitClient,
-		queueUnitInformer:    queueUnitInformer,
-		queueInformer:        queueInformer,
+		recorder:                 recorder,
+		fw:                       fw,
+		multiSchedulingQueue:     multiSchedulingQueue,
+		queueUnitClient:          queueUnitClient,
+		queueUnitInformer:        queueUnitInformer,
+		queueInformer:            queueInformer,
+		queueLister:              listers.NewQueueLister(queueInformer.GetIndexer()),
+		queueUnitLister:          listers.NewQueueUnitLister(queueUnitInformer.GetIndexer()),
+		queueDeletionPolicy:      queueDeletionPolicy,
+		startDeadline:            startDeadline,
+		maxStartDeadlineExpiries: maxStartDeadlineExpiries,
+	}
+	priorityClassInformer := informersFactory.Scheduling().V1().PriorityClasses().Informer()
+	resourceQuotaInformer := informersFactory.Core().V1().ResourceQuotas().Informer()
+	if dynamicClient != nil {
+		controller.consumers = newConsumerTracker(dynamicClient, restMapper, stopCh, controller.finishQueueUnit)
+	}
+	// the pods tell the started consumers, both for the start deadline and for the
+	// usage the ResourceQuota plugin finds in the status of the quotas
+	podInformer := informersFactory.Core().V1().Pods()
+	controller.podLister = podInformer.Lister()
+	controller.cacheSyncs = []cache.InformerSynced{queueUnitInformer.HasSynced, queueInformer.HasSynced, resourceQuotaInformer.HasSynced, podInformer.Informer().HasSynced}
+	controller.addAllEventHandlers(queueUnitInformer, queueInformer, priorityClassInformer, resourceQuotaInformer)
+	// the nodes are watched only for the plugins checking the capacity of the cluster
+	if fw.IsEventRegistered(framework.NodeUpdated) {
+		nodeInformer := informersFactory.Core().V1().Nodes().Informer()
+		controller.cacheSyncs = append(controller.cacheSyncs, nodeInformer.HasSynced)
+		controller.addCapacityEventHandlers(podInformer.Informer(), nodeInformer)
 	}
-	controller.addAllEventHandlers(queueUnitInformer, queueInformer)
 	go controller.queueInformer.Run(stopCh)
 	go controller.queueUnitInformer.Run(stopCh)
+	go wait.Until(controller.syncTerminatingQueues, 10*time.Second, stopCh)
+	reconciler := newReconciler(multiSchedulingQueue, fw, controller.queueUnitLister)
+	go reconciler.run(stopCh, controller.cacheSyncs...)
+	if startDeadline > 0 {
+		go wait.Until(controller.checkStartDeadlines, startDeadlineCheckInterval, stopCh)
+	}
 
-	controller.scheduler, err = scheduler.NewScheduler(multiSchedulingQueue, fw, queueUnitClient)
+	controller.scheduler, err = scheduler.NewScheduler(multiSchedulingQueue, fw, queueUnitClient, parallelism)
 	if err != nil {
 		klog.Fatalf("init scheduler failed %s", err)
 	}
@@ -100,8 +164,37 @@ func NewController(
 	return controller, nil
 }
 
+// Start rebuilds the reservations of the dequeued units once the informer caches are
+// synced, and only then starts scheduling, so that no unit is dequeued against the
+// reservations of an incomplete cache.
 func (c *Controller) Start(ctx context.Context) {
-	// c.multiSchedulingQueue.Run()
+	if !cache.WaitForCacheSync(ctx.Done(), c.cacheSyncs...) {
+		klog.Errorf("wait for cache sync fail, scheduler not started")
+		return
+	}
+	if err := c.restoreReservations(); err != nil {
+		klog.Errorf("restore reservations fail %v", err)
+		return
+	}
 	c.scheduler.Start(ctx)
 	c.multiSchedulingQueue.Close()
 }
+
+// restoreReservations replays the dequeued units into the reserve plugins, the
+// units reserved by the event handlers already are skipped by the plugins
+func (c *Controller) restoreReservations() error {
+	units, err := c.queueUnitLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	restored := 0
+	for _, unit := range units {
+		if !utils.IsAdmitted(unit) {
+			continue
+		}
+		c.reserve(unit)
+		restored++
+	}
+	klog.Infof("restored reservations of %d dequeued units", restored)
+	return nil
+}
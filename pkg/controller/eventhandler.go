@@ -18,24 +18,33 @@ package controller
 
 import (
 	"context"
+	"reflect"
 
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog/v2"
 
 	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
 	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/queue/admissionwindow"
+	"github.com/kube-queue/kube-queue/pkg/utils"
 )
 
-func (c *Controller) addAllEventHandlers(queueUnitInformer cache.SharedIndexInformer, queueInformer cache.SharedIndexInformer) {
+// ReasonInvalidAdmissionWindows is the reason of the Events recorded on the Queues
+// with invalid admission windows.
+const ReasonInvalidAdmissionWindows = "InvalidAdmissionWindows"
+
+func (c *Controller) addAllEventHandlers(queueUnitInformer cache.SharedIndexInformer, queueInformer cache.SharedIndexInformer, priorityClassInformer cache.SharedIndexInformer, resourceQuotaInformer cache.SharedIndexInformer) {
 	queueUnitInformer.AddEventHandler(
 		cache.FilteringResourceEventHandler{
 			FilterFunc: func(obj interface{}) bool {
-				switch qu := obj.(type) {
+				switch t := obj.(type) {
 				case *v1alpha1.QueueUnit:
-					if qu.Status.Phase != v1alpha1.Dequeued {
-						return true
-					}
-					return false
+					return true
+				case cache.DeletedFinalStateUnknown:
+					_, ok := t.Obj.(*v1alpha1.QueueUnit)
+					return ok
 				default:
 					return false
 				}
@@ -48,26 +57,6 @@ func (c *Controller) addAllEventHandlers(queueUnitInformer cache.SharedIndexInfo
 		},
 	)
 
-	queueUnitInformer.AddEventHandler(
-		cache.FilteringResourceEventHandler{
-			FilterFunc: func(obj interface{}) bool {
-				switch qu := obj.(type) {
-				case *v1alpha1.QueueUnit:
-					if qu.Status.Phase == v1alpha1.Dequeued {
-						return true
-					}
-					return false
-				default:
-					return false
-				}
-			},
-			Handler: cache.ResourceEventHandlerFuncs{
-				AddFunc:    c.AddDequeuedQueueUnit,
-				DeleteFunc: c.DeleteDequeuedQueueUnit,
-			},
-		},
-	)
-
 	queueInformer.AddEventHandler(
 		cache.FilteringResourceEventHandler{
 			FilterFunc: func(obj interface{}) bool {
@@ -85,11 +74,44 @@ func (c *Controller) addAllEventHandlers(queueUnitInformer cache.SharedIndexInfo
 			},
 		},
 	)
+
+	priorityClassInformer.AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.AddPriorityClass,
+			UpdateFunc: c.UpdatePriorityClass,
+			DeleteFunc: c.DeletePriorityClass,
+		},
+	)
+
+	resourceQuotaInformer.AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.AddResourceQuota,
+			UpdateFunc: c.UpdateResourceQuota,
+		},
+	)
+}
+
+// addCapacityEventHandlers watches the pods and the nodes which change the capacity
+// left in the cluster
+func (c *Controller) addCapacityEventHandlers(podInformer cache.SharedIndexInformer, nodeInformer cache.SharedIndexInformer) {
+	podInformer.AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			UpdateFunc: c.UpdatePod,
+			DeleteFunc: c.DeletePod,
+		},
+	)
+
+	nodeInformer.AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.AddNode,
+			UpdateFunc: c.UpdateNode,
+		},
+	)
 }
 
 func (c *Controller) AddQueue(obj interface{}) {
 	queue := obj.(*v1alpha1.Queue)
-	queueName := queue.Namespace
+	queueName := utils.QueueKey(queue.Namespace, queue.Name)
 	_, ok := c.multiSchedulingQueue.GetQueueByName(queueName)
 	if ok {
 		klog.Errorf("queue is exist %s", queueName)
@@ -99,6 +121,8 @@ func (c *Controller) AddQueue(obj interface{}) {
 	if err != nil {
 		klog.Errorf("add queue err %v", err)
 	}
+	c.syncQueueFinalizer(queue)
+	c.checkAdmissionWindows(queue)
 }
 
 func (c *Controller) UpdateQueue(oldObj, newObj interface{}) {
@@ -106,7 +130,24 @@ func (c *Controller) UpdateQueue(oldObj, newObj interface{}) {
 	newQ := newObj.(*v1alpha1.Queue)
 	err := c.multiSchedulingQueue.Update(oldQ, newQ)
 	if err != nil {
-		klog.Errorf("queue %s update fail %v", oldQ.Namespace, err.Error())
+		klog.Errorf("queue %s/%s update fail %v", oldQ.Namespace, oldQ.Name, err.Error())
+		return
+	}
+	c.syncQueueFinalizer(newQ)
+	if oldQ.ResourceVersion == newQ.ResourceVersion {
+		return
+	}
+	c.checkAdmissionWindows(newQ)
+	if q, ok := c.multiSchedulingQueue.GetQueueByName(utils.QueueKey(newQ.Namespace, newQ.Name)); ok {
+		q.MoveAllToActiveQueue(framework.QueueUpdated)
+	}
+}
+
+// checkAdmissionWindows records a warning Event on the Queue when its admission
+// windows are invalid, since the Queue admits no unit until they are fixed
+func (c *Controller) checkAdmissionWindows(queue *v1alpha1.Queue) {
+	if _, err := admissionwindow.Parse(queue); err != nil {
+		c.recorder.Eventf(queue, corev1.EventTypeWarning, ReasonInvalidAdmissionWindows, "%v, no unit is admitted until the admission windows are fixed", err)
 	}
 }
 
@@ -114,67 +155,249 @@ func (c *Controller) DeleteQueue(obj interface{}) {
 	queue := obj.(*v1alpha1.Queue)
 	err := c.multiSchedulingQueue.Delete(queue)
 	if err != nil {
-		klog.Errorf("queue %s delete fail %v", queue.Namespace, err.Error())
+		klog.Errorf("queue %s/%s delete fail %v", queue.Namespace, queue.Name, err.Error())
+	}
+}
+
+// unitState is the state of a QueueUnit for the controller
+type unitState int
+
+const (
+	// pendingUnit waits in its queue
+	pendingUnit unitState = iota
+	// admittedUnit left its queue and holds its reservations
+	admittedUnit
+	// finishedUnit neither waits in its queue nor holds reservations
+	finishedUnit
+)
+
+func unitStateOf(unit *v1alpha1.QueueUnit) unitState {
+	switch {
+	case utils.IsAdmitted(unit):
+		return admittedUnit
+	case utils.IsFinished(unit):
+		return finishedUnit
+	default:
+		return pendingUnit
 	}
 }
 
 func (c *Controller) AddQueueUnit(obj interface{}) {
 	unit := obj.(*v1alpha1.QueueUnit)
-	// Namespace is key of queueMap
-	queueName := unit.Spec.ConsumerRef.Namespace
-	q, ok := c.multiSchedulingQueue.GetQueueByName(queueName)
-	if !ok {
-		klog.Errorf("queue is not exist %s", queueName)
+	c.enterState(unit)
+}
+
+// UpdateQueueUnit moves the unit between the queues and the reservations when its
+// phase changes
+func (c *Controller) UpdateQueueUnit(oldObj, newObj interface{}) {
+	oldQu := oldObj.(*v1alpha1.QueueUnit)
+	newQu := newObj.(*v1alpha1.QueueUnit)
+	oldState, newState := unitStateOf(oldQu), unitStateOf(newQu)
+	if oldState != newState {
+		klog.Infof("unit %s/%s moves from phase %q to %q", newQu.Namespace, newQu.Name, oldQu.Status.Phase, newQu.Status.Phase)
+		c.leaveState(oldQu)
+		c.enterState(newQu)
 		return
 	}
+	if newState == pendingUnit {
+		err := c.multiSchedulingQueue.UpdateUnit(oldQu, newQu)
+		if err != nil {
+			klog.Errorf("update unit %s/%s fail %v", newQu.Namespace, newQu.Name, err.Error())
+		}
+	}
+}
 
-	err := q.Add(unit)
-	if err != nil {
-		klog.Errorf("queue %s add unit fail %v", queueName, err.Error())
+func (c *Controller) DeleteQueueUnit(obj interface{}) {
+	var unit *v1alpha1.QueueUnit
+	switch t := obj.(type) {
+	case *v1alpha1.QueueUnit:
+		unit = t
+	case cache.DeletedFinalStateUnknown:
+		unit = t.Obj.(*v1alpha1.QueueUnit)
+	default:
+		return
 	}
+	c.leaveState(unit)
 }
 
-func (c *Controller) AddDequeuedQueueUnit(obj interface{}) {
-	unit := obj.(*v1alpha1.QueueUnit)
-	// TODO add reserveIfNotPresent
-	c.fw.RunReservePluginsReserve(context.TODO(), framework.NewQueueUnitInfo(unit))
+// enterState adds a pending unit to its queue, and reserves for an admitted unit
+func (c *Controller) enterState(unit *v1alpha1.QueueUnit) {
+	switch unitStateOf(unit) {
+	case pendingUnit:
+		err := c.multiSchedulingQueue.AddUnit(unit)
+		if err != nil {
+			klog.Errorf("add unit %s/%s fail %v", unit.Namespace, unit.Name, err.Error())
+		}
+	case admittedUnit:
+		c.reserve(unit)
+		c.consumers.track(unit)
+	}
 }
 
-func (c *Controller) DeleteQueueUnit(obj interface{}) {
-	unit := obj.(*v1alpha1.QueueUnit)
-	// Namespace is key of queueMap
-	queueName := unit.Spec.ConsumerRef.Namespace
-	q, ok := c.multiSchedulingQueue.GetQueueByName(queueName)
-	if !ok {
-		klog.Errorf("queue is not exist %s", queueName)
-		return
+// leaveState deletes a pending unit from its queue, and releases the reservations of
+// an admitted unit
+func (c *Controller) leaveState(unit *v1alpha1.QueueUnit) {
+	switch unitStateOf(unit) {
+	case pendingUnit:
+		// the unit becoming admitted is usually popped by the scheduler already
+		err := c.multiSchedulingQueue.DeleteUnit(unit)
+		if err != nil {
+			klog.Errorf("delete unit %s/%s fail %v", unit.Namespace, unit.Name, err.Error())
+		}
+	case admittedUnit:
+		c.consumers.untrack(unit)
+		c.unreserve(unit)
 	}
+}
 
-	err := q.Delete(unit)
-	if err != nil {
-		klog.Errorf("queue %s delete unit fail %v", queueName, err.Error())
+// reserve reserves for the admitted unit, it is a no-op if the unit is reserved
+// already
+func (c *Controller) reserve(unit *v1alpha1.QueueUnit) {
+	info := framework.NewQueueUnitInfo(unit)
+	if q, ok := c.multiSchedulingQueue.GetQueueByUnit(unit); ok {
+		info.QueueName = q.Name()
+	}
+	status := c.fw.RunReservePluginsReserve(context.TODO(), info)
+	if status.Code() != framework.Success {
+		klog.Errorf("reserve unit %s/%s fail %v", unit.Namespace, unit.Name, status.Message())
 	}
 }
 
-func (c *Controller) DeleteDequeuedQueueUnit(obj interface{}) {
-	unit := obj.(*v1alpha1.QueueUnit)
-	// TODO add unreserveIfNotPresent
+// unreserve releases the reservations of the unit, and retries the units which may
+// fit now
+func (c *Controller) unreserve(unit *v1alpha1.QueueUnit) {
 	c.fw.RunReservePluginsUnreserve(context.TODO(), framework.NewQueueUnitInfo(unit))
+	c.multiSchedulingQueue.MoveAllToActiveQueue(framework.QueueUnitDeleted)
 }
 
-func (c *Controller) UpdateQueueUnit(oldObj, newObj interface{}) {
-	oldQu := oldObj.(*v1alpha1.QueueUnit)
-	newQu := newObj.(*v1alpha1.QueueUnit)
-	// Namespace is key of queueMap
-	queueName := newQu.Spec.ConsumerRef.Namespace
-	q, ok := c.multiSchedulingQueue.GetQueueByName(queueName)
-	if !ok {
-		klog.Errorf("queue is not exist %s", queueName)
+// AddPriorityClass re-sorts the queues, since the units referring to the new
+// PriorityClass or without PriorityClass may change priority
+func (c *Controller) AddPriorityClass(obj interface{}) {
+	c.multiSchedulingQueue.Resort()
+}
+
+func (c *Controller) UpdatePriorityClass(oldObj, newObj interface{}) {
+	oldPC := oldObj.(*schedulingv1.PriorityClass)
+	newPC := newObj.(*schedulingv1.PriorityClass)
+	if oldPC.Value == newPC.Value && oldPC.GlobalDefault == newPC.GlobalDefault {
 		return
 	}
+	klog.Infof("priority class %s changed, resort queues", newPC.Name)
+	c.multiSchedulingQueue.Resort()
+}
 
-	err := q.Update(oldQu, newQu)
-	if err != nil {
-		klog.Errorf("queue %s update unit fail %v", queueName, err.Error())
+func (c *Controller) DeletePriorityClass(obj interface{}) {
+	c.multiSchedulingQueue.Resort()
+}
+
+// AddResourceQuota moves the units out of backoff, since the units waiting for a
+// ResourceQuota may become schedulable
+func (c *Controller) AddResourceQuota(obj interface{}) {
+	c.multiSchedulingQueue.MoveAllToActiveQueue(framework.ResourceQuotaUpdated)
+}
+
+// UpdateResourceQuota moves the units out of backoff when a hard limit of the
+// ResourceQuota is raised, its scopes change or workloads release their usage
+func (c *Controller) UpdateResourceQuota(oldObj, newObj interface{}) {
+	oldRQ := oldObj.(*corev1.ResourceQuota)
+	newRQ := newObj.(*corev1.ResourceQuota)
+	switch {
+	case hardLimitRaised(oldRQ.Spec.Hard, newRQ.Spec.Hard):
+		klog.Infof("hard limit of resource quota %s/%s raised", newRQ.Namespace, newRQ.Name)
+	case !reflect.DeepEqual(oldRQ.Spec.Scopes, newRQ.Spec.Scopes) || !reflect.DeepEqual(oldRQ.Spec.ScopeSelector, newRQ.Spec.ScopeSelector):
+		klog.Infof("scopes of resource quota %s/%s changed", newRQ.Namespace, newRQ.Name)
+	case usageDecreased(oldRQ.Status.Used, newRQ.Status.Used):
+		klog.V(4).Infof("usage of resource quota %s/%s decreased", newRQ.Namespace, newRQ.Name)
+	default:
+		return
+	}
+	c.multiSchedulingQueue.MoveAllToActiveQueue(framework.ResourceQuotaUpdated)
+}
+
+// hardLimitRaised returns true if a hard limit is raised or removed
+func hardLimitRaised(oldHard, newHard corev1.ResourceList) bool {
+	for rName, oldQuantity := range oldHard {
+		newQuantity, ok := newHard[rName]
+		if !ok || newQuantity.Cmp(oldQuantity) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// usageDecreased returns true if a used resource of a ResourceQuota is decreased or
+// released
+func usageDecreased(oldUsed, newUsed corev1.ResourceList) bool {
+	for rName, oldQuantity := range oldUsed {
+		newQuantity, ok := newUsed[rName]
+		if !ok || newQuantity.Cmp(oldQuantity) < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdatePod moves the units out of backoff when a pod bound to a node terminates,
+// since the units waiting for capacity may become schedulable
+func (c *Controller) UpdatePod(oldObj, newObj interface{}) {
+	oldPod := oldObj.(*corev1.Pod)
+	newPod := newObj.(*corev1.Pod)
+	if newPod.Spec.NodeName == "" || utils.IsPodTerminated(oldPod) || !utils.IsPodTerminated(newPod) {
+		return
+	}
+	c.multiSchedulingQueue.MoveAllToActiveQueue(framework.PodTerminated)
+}
+
+// DeletePod moves the units out of backoff when a pod bound to a node is deleted
+// before it terminated
+func (c *Controller) DeletePod(obj interface{}) {
+	var pod *corev1.Pod
+	switch t := obj.(type) {
+	case *corev1.Pod:
+		pod = t
+	case cache.DeletedFinalStateUnknown:
+		var ok bool
+		if pod, ok = t.Obj.(*corev1.Pod); !ok {
+			return
+		}
+	default:
+		return
+	}
+	if pod.Spec.NodeName == "" || utils.IsPodTerminated(pod) {
+		return
+	}
+	c.multiSchedulingQueue.MoveAllToActiveQueue(framework.PodTerminated)
+}
+
+// AddNode moves the units out of backoff, since the units waiting for capacity may
+// become schedulable
+func (c *Controller) AddNode(obj interface{}) {
+	c.multiSchedulingQueue.MoveAllToActiveQueue(framework.NodeUpdated)
+}
+
+// UpdateNode moves the units out of backoff when a node becomes schedulable or its
+// allocatable resource is raised
+func (c *Controller) UpdateNode(oldObj, newObj interface{}) {
+	oldNode := oldObj.(*corev1.Node)
+	newNode := newObj.(*corev1.Node)
+	if !utils.IsNodeSchedulable(newNode) {
+		return
+	}
+	if utils.IsNodeSchedulable(oldNode) && !allocatableRaised(oldNode.Status.Allocatable, newNode.Status.Allocatable) {
+		return
+	}
+	klog.V(4).Infof("capacity of node %s raised", newNode.Name)
+	c.multiSchedulingQueue.MoveAllToActiveQueue(framework.NodeUpdated)
+}
+
+// allocatableRaised returns true if an allocatable resource of a node is raised or
+// added
+func allocatableRaised(oldAllocatable, newAllocatable corev1.ResourceList) bool {
+	for rName, newQuantity := range newAllocatable {
+		oldQuantity, ok := oldAllocatable[rName]
+		if !ok || newQuantity.Cmp(oldQuantity) > 0 {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,110 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/priority"
+	"github.com/kube-queue/kube-queue/pkg/framework/runtime"
+	"github.com/kube-queue/kube-queue/pkg/queue/multischedulingqueue"
+)
+
+type fakeReservePlugin struct {
+	reserved map[string]bool
+}
+
+func (f *fakeReservePlugin) Name() string {
+	return "Fake"
+}
+
+func (f *fakeReservePlugin) Reserve(ctx context.Context, unit *framework.QueueUnitInfo) *framework.Status {
+	f.reserved[unit.Name] = true
+	return framework.NewStatus(framework.Success, "")
+}
+
+func (f *fakeReservePlugin) Unreserve(ctx context.Context, unit *framework.QueueUnitInfo) {
+	delete(f.reserved, unit.Name)
+}
+
+func TestQueueUnitPhaseTransitions(t *testing.T) {
+	plugin := &fakeReservePlugin{reserved: make(map[string]bool)}
+	registry := runtime.Registry{
+		priority.Name: priority.New,
+		"Fake": func(_ k8sruntime.Object, _ framework.Handle) (framework.Plugin, error) {
+			return plugin, nil
+		},
+	}
+	fw, err := runtime.NewFramework(registry, nil, "", informers.NewSharedInformerFactory(nil, 0), nil, nil)
+	if err != nil {
+		t.Fatalf("new framework failed %v", err)
+	}
+	mq, err := multischedulingqueue.NewMultiSchedulingQueue(fw, 1, 20, 30, 300, "default", "")
+	if err != nil {
+		t.Fatalf("new multi scheduling queue failed %v", err)
+	}
+	defer mq.Close()
+	c := &Controller{fw: fw, multiSchedulingQueue: mq}
+	c.AddQueue(&v1alpha1.Queue{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "ns"}})
+
+	phases := []struct {
+		phase    v1alpha1.QueueUnitPhase
+		pending  int
+		reserved int
+	}{
+		{v1alpha1.Enqueued, 1, 0},
+		{v1alpha1.Dequeued, 0, 1},
+		{v1alpha1.Enqueued, 1, 0},
+		{v1alpha1.SchedReady, 0, 1},
+		{v1alpha1.SchedFailed, 0, 0},
+		{v1alpha1.Enqueued, 1, 0},
+		{v1alpha1.Dequeued, 0, 1},
+		{v1alpha1.SchedSucceed, 0, 0},
+	}
+	var old *v1alpha1.QueueUnit
+	for _, p := range phases {
+		unit := &v1alpha1.QueueUnit{
+			ObjectMeta: metav1.ObjectMeta{Name: "qu1", Namespace: "ns"},
+			Status:     v1alpha1.QueueUnitStatus{Phase: p.phase},
+		}
+		if old == nil {
+			c.AddQueueUnit(unit)
+		} else {
+			c.UpdateQueueUnit(old, unit)
+		}
+		if got := len(mq.Units()); got != p.pending {
+			t.Errorf("%s: expected %d pending units, got %d", p.phase, p.pending, got)
+		}
+		if got := len(plugin.reserved); got != p.reserved {
+			t.Errorf("%s: expected %d reservations, got %d", p.phase, p.reserved, got)
+		}
+		old = unit
+	}
+
+	c.DeleteQueueUnit(cache.DeletedFinalStateUnknown{Key: "ns/qu1", Obj: old})
+	if len(mq.Units()) != 0 || len(plugin.reserved) != 0 {
+		t.Errorf("expected the deleted unit to be released, got %d pending units and %d reservations", len(mq.Units()), len(plugin.reserved))
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+// QueueDeletionPolicy decides what happens to the pending units of a deleted Queue
+type QueueDeletionPolicy string
+
+const (
+	// QueueDeletionBlock blocks the deletion of a Queue with a finalizer until it has
+	// no pending unit.
+	QueueDeletionBlock QueueDeletionPolicy = "Block"
+	// QueueDeletionMove moves the pending units of a deleted Queue to the fallback
+	// queue, or parks them until their queue is added again.
+	QueueDeletionMove QueueDeletionPolicy = "Move"
+)
+
+// syncQueueFinalizer adds the queue protection finalizer to the Queue with the Block
+// deletion policy, and removes it once the Queue is terminating without pending units
+func (c *Controller) syncQueueFinalizer(queue *v1alpha1.Queue) {
+	has := hasFinalizer(queue, utils.QueueProtectionFinalizer)
+	if queue.DeletionTimestamp == nil {
+		if want := c.queueDeletionPolicy == QueueDeletionBlock; want != has {
+			c.setQueueFinalizer(queue, want)
+		}
+		return
+	}
+	if !has {
+		return
+	}
+	if c.queueDeletionPolicy == QueueDeletionBlock {
+		q, ok := c.multiSchedulingQueue.GetQueueByName(utils.QueueKey(queue.Namespace, queue.Name))
+		if ok && len(q.Units()) > 0 {
+			klog.Infof("queue %s/%s has %d pending units, block its deletion", queue.Namespace, queue.Name, len(q.Units()))
+			return
+		}
+	}
+	c.setQueueFinalizer(queue, false)
+}
+
+// syncTerminatingQueues releases the terminating Queues whose pending units are gone
+func (c *Controller) syncTerminatingQueues() {
+	queues, err := c.queueLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("list queues fail %v", err)
+		return
+	}
+	for _, queue := range queues {
+		if queue.DeletionTimestamp != nil {
+			c.syncQueueFinalizer(queue)
+		}
+	}
+}
+
+func (c *Controller) setQueueFinalizer(queue *v1alpha1.Queue, add bool) {
+	newQueue, err := c.queueUnitClient.SchedulingV1alpha1().Queues(queue.Namespace).Get(context.TODO(), queue.Name, metav1.GetOptions{})
+	if err != nil {
+		klog.Errorf("get queue %s/%s fail %v", queue.Namespace, queue.Name, err)
+		return
+	}
+	if hasFinalizer(newQueue, utils.QueueProtectionFinalizer) == add {
+		return
+	}
+	if add {
+		newQueue.Finalizers = append(newQueue.Finalizers, utils.QueueProtectionFinalizer)
+	} else {
+		finalizers := make([]string, 0, len(newQueue.Finalizers))
+		for _, f := range newQueue.Finalizers {
+			if f != utils.QueueProtectionFinalizer {
+				finalizers = append(finalizers, f)
+			}
+		}
+		newQueue.Finalizers = finalizers
+	}
+	_, err = c.queueUnitClient.SchedulingV1alpha1().Queues(queue.Namespace).Update(context.TODO(), newQueue, metav1.UpdateOptions{})
+	if err != nil {
+		klog.Errorf("update finalizers of queue %s/%s fail %v", queue.Namespace, queue.Name, err)
+	}
+}
+
+func hasFinalizer(obj metav1.Object, finalizer string) bool {
+	for _, f := range obj.GetFinalizers() {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
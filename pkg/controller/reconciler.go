@@ -0,0 +1,156 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	listers "github.com/kube-queue/api/pkg/client/listers/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/metrics"
+	"github.com/kube-queue/kube-queue/pkg/queue"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+// reconcileInterval is how often the in-memory queues and reservations are
+// reconciled with the QueueUnit informer cache
+const reconcileInterval = time.Minute
+
+// reconciler repairs the drift between the QueueUnit informer cache and the in-memory
+// queues and reservations left by missed events or failed handlers.
+//
+// The cache is updated before the handlers run, and a unit being dequeued is popped
+// and reserved before its phase is updated, so a drift is repaired only when it is
+// observed in two consecutive passes for the same version of the unit.
+type reconciler struct {
+	multiSchedulingQueue queue.MultiSchedulingQueue
+	fw                   framework.Framework
+	queueUnitLister      listers.QueueUnitLister
+	// suspects are the drifts observed in the last pass
+	suspects sets.String
+	// observed are the drifts observed in the current pass
+	observed sets.String
+}
+
+func newReconciler(mq queue.MultiSchedulingQueue, fw framework.Framework, queueUnitLister listers.QueueUnitLister) *reconciler {
+	return &reconciler{
+		multiSchedulingQueue: mq,
+		fw:                   fw,
+		queueUnitLister:      queueUnitLister,
+		suspects:             sets.NewString(),
+	}
+}
+
+// confirm records the drift and returns true if it was observed in the last pass
+func (r *reconciler) confirm(kind string, unit *v1alpha1.QueueUnit) bool {
+	drift := kind + "/" + unit.Namespace + "/" + unit.Name + "/" + unit.ResourceVersion
+	r.observed.Insert(drift)
+	return r.suspects.Has(drift)
+}
+
+func (r *reconciler) reconcile() {
+	units, err := r.queueUnitLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("list queue units fail %v", err)
+		return
+	}
+	r.observed = sets.NewString()
+	defer func() {
+		r.suspects = r.observed
+	}()
+
+	pending := make(map[string]*v1alpha1.QueueUnit)
+	dequeued := make(map[string]*framework.QueueUnitInfo)
+	for _, unit := range units {
+		if utils.IsFinished(unit) {
+			continue
+		}
+		key := unit.Namespace + "/" + unit.Name
+		if utils.IsAdmitted(unit) {
+			info := framework.NewQueueUnitInfo(unit)
+			if q, ok := r.multiSchedulingQueue.GetQueueByUnit(unit); ok {
+				info.QueueName = q.Name()
+			}
+			dequeued[key] = info
+			continue
+		}
+		pending[key] = unit
+	}
+
+	known := make(map[string]*v1alpha1.QueueUnit)
+	for _, unit := range r.multiSchedulingQueue.Units() {
+		known[unit.Namespace+"/"+unit.Name] = unit
+	}
+	for key, unit := range pending {
+		old, ok := known[key]
+		switch {
+		case !ok:
+			if r.confirm("add", unit) {
+				klog.Infof("queue unit %s is missing from the queues, add it", key)
+				r.repair("unit_added", r.multiSchedulingQueue.AddUnit(unit))
+			}
+		case old.ResourceVersion != unit.ResourceVersion:
+			if r.confirm("update", unit) {
+				klog.Infof("queue unit %s is stale in the queues, update it", key)
+				r.repair("unit_updated", r.multiSchedulingQueue.UpdateUnit(old, unit))
+			}
+		}
+	}
+	for key, unit := range known {
+		if _, ok := pending[key]; ok {
+			continue
+		}
+		if r.confirm("delete", unit) {
+			klog.Infof("queue unit %s is not pending, delete it from the queues", key)
+			r.repair("unit_deleted", r.multiSchedulingQueue.DeleteUnit(unit))
+		}
+	}
+
+	reserved, unreserved := r.fw.ReconcileReservations(context.TODO(), dequeued, func(plugin string, info *framework.QueueUnitInfo) bool {
+		return r.confirm("reservation/"+plugin, info.Unit)
+	})
+	metrics.ReconcileRepairs.Add("reservation_added", int64(reserved))
+	metrics.ReconcileRepairs.Add("reservation_deleted", int64(unreserved))
+	if unreserved > 0 {
+		r.multiSchedulingQueue.MoveAllToActiveQueue(framework.QueueUnitDeleted)
+	}
+}
+
+// run reconciles periodically once the informer caches are synced
+func (r *reconciler) run(stopCh <-chan struct{}, cacheSyncs ...cache.InformerSynced) {
+	if !cache.WaitForCacheSync(stopCh, cacheSyncs...) {
+		klog.Errorf("wait for cache sync fail, reconciler not started")
+		return
+	}
+	wait.Until(r.reconcile, reconcileInterval, stopCh)
+}
+
+func (r *reconciler) repair(kind string, err error) {
+	if err != nil {
+		klog.Errorf("repair %s fail %v", kind, err)
+		return
+	}
+	metrics.ReconcileRepairs.Add(kind, 1)
+}
@@ -0,0 +1,89 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+// startDeadlineCheckInterval is how often the start deadlines of the dequeued units
+// are checked
+const startDeadlineCheckInterval = 10 * time.Second
+
+// checkStartDeadlines requeues the dequeued units whose consumer has no running pod
+// within the start deadline, or fails them after maxStartDeadlineExpiries expiries if
+// it is not 0. Their reservations are released by the event handlers on the phase change.
+func (c *Controller) checkStartDeadlines() {
+	units, err := c.queueUnitLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("list queue units fail %v", err)
+		return
+	}
+	now := time.Now()
+	for _, unit := range units {
+		if !utils.IsAdmitted(unit) || unit.Spec.ConsumerRef == nil {
+			continue
+		}
+		dequeued, ok := utils.DequeueTimestamp(unit)
+		if !ok || now.Sub(dequeued) < c.startDeadline || c.consumerStarted(unit) {
+			continue
+		}
+		c.expireStartDeadline(unit)
+	}
+}
+
+// consumerStarted returns true if a pod of the consumer of the unit is running or
+// ran already
+func (c *Controller) consumerStarted(unit *v1alpha1.QueueUnit) bool {
+	pods, err := c.podLister.Pods(utils.QueueUnitNamespace(unit)).List(labels.Everything())
+	if err != nil {
+		klog.Errorf("list pods fail %v", err)
+		return true
+	}
+	return utils.ConsumerStarted(unit, pods)
+}
+
+func (c *Controller) expireStartDeadline(unit *v1alpha1.QueueUnit) {
+	newUnit := unit.DeepCopy()
+	expiries := utils.StartDeadlineExpiries(unit) + 1
+	if newUnit.Annotations == nil {
+		newUnit.Annotations = make(map[string]string)
+	}
+	newUnit.Annotations[utils.AnnotationStartDeadlineExpiries] = strconv.Itoa(expiries)
+	if c.maxStartDeadlineExpiries > 0 && expiries >= c.maxStartDeadlineExpiries {
+		newUnit.Status.Phase = v1alpha1.SchedFailed
+		newUnit.Status.Message = fmt.Sprintf("consumer did not start within %v after being dequeued %d times", c.startDeadline, expiries)
+	} else {
+		newUnit.Status.Phase = v1alpha1.Enqueued
+		newUnit.Status.Message = fmt.Sprintf("requeued since consumer did not start within %v", c.startDeadline)
+	}
+	klog.Infof("unit %s/%s: %s", unit.Namespace, unit.Name, newUnit.Status.Message)
+	_, err := c.queueUnitClient.SchedulingV1alpha1().QueueUnits(unit.Namespace).Update(context.TODO(), newUnit, metav1.UpdateOptions{})
+	if err != nil {
+		klog.Errorf("update unit %s/%s fail %v", unit.Namespace, unit.Name, err)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+)
+
+func TestConsumerStarted(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	c := &Controller{podLister: corelisters.NewPodLister(indexer)}
+	unit := &v1alpha1.QueueUnit{
+		ObjectMeta: metav1.ObjectMeta{Name: "qu1", Namespace: "ns"},
+		Spec: v1alpha1.QueueUnitSpec{
+			ConsumerRef: &corev1.ObjectReference{Kind: "TFJob", Namespace: "ns", Name: "job"},
+		},
+	}
+	if c.consumerStarted(unit) {
+		t.Fatalf("expected consumer without pod not to be started")
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "job-worker-0",
+			Namespace:       "ns",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "TFJob", Name: "job"}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodPending},
+	}
+	indexer.Add(pod)
+	if c.consumerStarted(unit) {
+		t.Fatalf("expected consumer with pending pod not to be started")
+	}
+
+	running := pod.DeepCopy()
+	running.Status.Phase = corev1.PodRunning
+	indexer.Update(running)
+	if !c.consumerStarted(unit) {
+		t.Errorf("expected consumer with running pod to be started")
+	}
+}
@@ -18,10 +18,13 @@ package framework
 
 import (
 	"context"
+	"time"
 
 	"github.com/kube-queue/api/pkg/client/clientset/versioned"
+	"github.com/kube-queue/api/pkg/client/informers/externalversions"
 
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/informers"
 )
 
@@ -50,19 +53,66 @@ const (
 	Skip
 )
 
+// ClusterEvent is an event that may make QueueUnits rejected by the filter plugins
+// schedulable.
+type ClusterEvent string
+
+const (
+	// QueueUnitDeleted is the event of a Dequeued QueueUnit being deleted and
+	// releasing its resources.
+	QueueUnitDeleted ClusterEvent = "QueueUnitDeleted"
+	// ResourceQuotaUpdated is the event of a ResourceQuota being added or its hard
+	// limits being raised.
+	ResourceQuotaUpdated ClusterEvent = "ResourceQuotaUpdated"
+	// QueueUpdated is the event of a Queue being updated.
+	QueueUpdated ClusterEvent = "QueueUpdated"
+	// NodeUpdated is the event of a node being added or its capacity for new pods
+	// being raised.
+	NodeUpdated ClusterEvent = "NodeUpdated"
+	// PodTerminated is the event of a pod bound to a node terminating and releasing
+	// its requests.
+	PodTerminated ClusterEvent = "PodTerminated"
+)
+
 type Framework interface {
 	// QueueSortFunc returns the function to sort pods in scheduling queue
 	MultiQueueSortFunc() MultiQueueLessFunc
 	QueueSortFuncMap() map[string]QueueLessFunc
+	// QueueSortRefreshInterval returns how often the queues sorted by the given plugin
+	// must be re-sorted, 0 if the ordering of the plugin does not change over time.
+	QueueSortRefreshInterval(pluginName string) time.Duration
+	// RunQueueUnitAdded notifies the queue sort plugin of the given name that the
+	// QueueUnit is added to one of the queues it sorts.
+	RunQueueUnitAdded(pluginName string, unit *QueueUnitInfo)
+	// RunQueueUnitDeleted notifies the queue sort plugin of the given name that the
+	// QueueUnit leaves one of the queues it sorts.
+	RunQueueUnitDeleted(pluginName string, unit *QueueUnitInfo)
 	RunFilterPlugins(context.Context, *QueueUnitInfo) *Status
 	RunScorePlugins(context.Context) (int64, bool)
+	// RunReservePluginsReserve reserves for the QueueUnit in every reserve plugin. It
+	// unreserves the plugins which succeeded already if one of them fails.
 	RunReservePluginsReserve(context.Context, *QueueUnitInfo) *Status
 	RunReservePluginsUnreserve(context.Context, *QueueUnitInfo)
+	// IsEventRelevant returns true if the event may make a QueueUnit rejected by the
+	// given filter plugins schedulable. Plugins which do not declare their events are
+	// assumed to be interested in all of them.
+	IsEventRelevant(event ClusterEvent, unschedulablePlugins sets.String) bool
+	// IsEventRegistered returns true if a filter plugin registered the event, so that
+	// the objects the event is about need not be watched otherwise.
+	IsEventRegistered(event ClusterEvent) bool
+	// ReconcileReservations reserves the dequeued QueueUnits, keyed by namespace/name,
+	// which the ReservationLister plugins hold no reservation for, and unreserves the
+	// reservations of the QueueUnits which are not dequeued. QueueUnits are matched by
+	// UID. A drift is repaired only
+	// if confirm returns true for the plugin and the QueueUnit.
+	ReconcileReservations(ctx context.Context, dequeued map[string]*QueueUnitInfo, confirm func(plugin string, unit *QueueUnitInfo) bool) (reserved int, unreserved int)
 }
 
 type Status struct {
 	message string
 	code    Code
+	// failedPlugin is the name of the plugin which returned the status.
+	failedPlugin string
 }
 
 // NewStatus makes a Status out of the given arguments and returns its pointer.
@@ -82,6 +132,16 @@ func (s *Status) Message() string {
 	return s.message
 }
 
+// FailedPlugin returns the name of the plugin which failed, empty on success.
+func (s *Status) FailedPlugin() string {
+	return s.failedPlugin
+}
+
+// SetFailedPlugin records the name of the plugin which failed.
+func (s *Status) SetFailedPlugin(plugin string) {
+	s.failedPlugin = plugin
+}
+
 // Plugin is the parent type for all the scheduling framework plugins.
 type Plugin interface {
 	Name() string
@@ -101,12 +161,37 @@ type QueueSortPlugin interface {
 
 type QueueLessFunc func(*QueueUnitInfo, *QueueUnitInfo) bool
 
+// QueueSortRefreshPlugin is a QueueSortPlugin whose ordering changes over time,
+// the queues using it are re-sorted every RefreshInterval.
+type QueueSortRefreshPlugin interface {
+	QueueSortPlugin
+	RefreshInterval() time.Duration
+}
+
+// QueueUnitTracker is an optional interface of QueueSortPlugins keeping state about
+// the QueueUnits of the queues they sort. QueueUnitAdded is called in arrival order
+// when a QueueUnit is added to a queue, QueueUnitDeleted when it leaves the queue
+// for good, i.e. it is admitted, deleted or moved to another queue.
+type QueueUnitTracker interface {
+	QueueSortPlugin
+	QueueUnitAdded(*QueueUnitInfo)
+	QueueUnitDeleted(*QueueUnitInfo)
+}
+
 type FilterPlugin interface {
 	Plugin
 
 	Filter(ctx context.Context, QueueUnit *QueueUnitInfo) *Status
 }
 
+// EnqueueExtensions is an optional interface of FilterPlugins declaring the events
+// which may make the QueueUnits they rejected schedulable. On such an event the
+// rejected QueueUnits are moved out of backoff immediately.
+type EnqueueExtensions interface {
+	Plugin
+	EventsToRegister() []ClusterEvent
+}
+
 // ScorePlugin is an interface that must be implemented by "Score" plugins to rank
 // nodes that passed the filtering phase.
 type ScorePlugin interface {
@@ -120,8 +205,17 @@ type ReservePlugin interface {
 	Unreserve(ctx context.Context, QueueUnit *QueueUnitInfo)
 }
 
+// ReservationLister is an optional interface of ReservePlugins listing the QueueUnits
+// they hold a reservation for, so that the reservations can be reconciled with the
+// Dequeued QueueUnits of the cluster.
+type ReservationLister interface {
+	ReservePlugin
+	Reservations() []*QueueUnitInfo
+}
+
 type Handle interface {
 	SharedInformerFactory() informers.SharedInformerFactory
+	QueueInformerFactory() externalversions.SharedInformerFactory
 	KubeConfigPath() string
 	QueueUnitClient() *versioned.Clientset
 }
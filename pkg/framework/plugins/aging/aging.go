@@ -0,0 +1,123 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package aging
+
+import (
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/kubernetes/pkg/scheduler/util"
+
+	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/priority"
+)
+
+// Name is the name of the plugin used in the plugin registry and configurations.
+const Name = "Aging"
+
+const (
+	// DefaultRefreshInterval is the default interval to re-sort the queues.
+	DefaultRefreshInterval = 30 * time.Second
+)
+
+// Args holds the arguments used to configure the Aging plugin.
+type Args struct {
+	metav1.TypeMeta
+
+	// Rate is the priority gained by a unit for each minute it waits in the queue.
+	Rate float64
+	// Cap is the max priority a unit can gain by waiting, 0 means unlimited.
+	Cap int64
+	// RefreshInterval is how often the queues are re-sorted.
+	RefreshInterval time.Duration
+}
+
+// DeepCopyObject implements runtime.Object.
+func (in *Args) DeepCopyObject() runtime.Object {
+	out := *in
+	return &out
+}
+
+// Aging is a plugin that sorts units by effective priority, which grows with the
+// time since the unit was first queued, to prevent low priority units from
+// starving.
+type Aging struct {
+	args     Args
+	priority *priority.Priority
+	clock    util.Clock
+}
+
+var _ framework.QueueSortRefreshPlugin = &Aging{}
+
+// Name returns name of the plugin.
+func (a *Aging) Name() string {
+	return Name
+}
+
+func (a *Aging) QueueLess(u1 *framework.QueueUnitInfo, u2 *framework.QueueUnitInfo) bool {
+	now := a.clock.Now()
+	p1 := a.EffectivePriority(u1, now)
+	p2 := a.EffectivePriority(u2, now)
+	return (p1 > p2) || (p1 == p2 && u1.InitialAttemptTimestamp.Before(u2.InitialAttemptTimestamp))
+}
+
+// RefreshInterval returns how often the queues are re-sorted, since the
+// effective priority of the units changes over time.
+func (a *Aging) RefreshInterval() time.Duration {
+	return a.args.RefreshInterval
+}
+
+// EffectivePriority returns the priority of the given QueueUnitInfo plus the
+// priority it gained by waiting until now.
+func (a *Aging) EffectivePriority(u *framework.QueueUnitInfo, now time.Time) int64 {
+	base := int64(a.priority.QueueUnitPriority(u))
+	waited := now.Sub(u.InitialAttemptTimestamp)
+	if waited <= 0 {
+		return base
+	}
+	aged := int64(waited.Minutes() * a.args.Rate)
+	if a.args.Cap > 0 && aged > a.args.Cap {
+		aged = a.args.Cap
+	}
+	return base + aged
+}
+
+// New initializes a new plugin and returns it.
+func New(configuration runtime.Object, handle framework.Handle) (framework.Plugin, error) {
+	p, err := priority.New(nil, handle)
+	if err != nil {
+		return nil, err
+	}
+	a := &Aging{
+		args:     Args{RefreshInterval: DefaultRefreshInterval},
+		priority: p.(*priority.Priority),
+		clock:    util.RealClock{},
+	}
+	if configuration != nil {
+		args, ok := configuration.(*Args)
+		if !ok {
+			return nil, fmt.Errorf("want args to be of type *aging.Args, got %T", configuration)
+		}
+		a.args = *args
+		if a.args.RefreshInterval <= 0 {
+			a.args.RefreshInterval = DefaultRefreshInterval
+		}
+	}
+	return a, nil
+}
@@ -0,0 +1,71 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package aging
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/priority"
+)
+
+func TestQueueLess(t *testing.T) {
+	start := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	fakeClock := clock.NewFakeClock(start)
+	a := &Aging{
+		args:     Args{Rate: 1, Cap: 100},
+		priority: &priority.Priority{},
+		clock:    fakeClock,
+	}
+
+	old := makeQueueUnitInfo("old", 10, start)
+	young := makeQueueUnitInfo("young", 30, start.Add(30*time.Minute))
+
+	fakeClock.SetTime(start.Add(10 * time.Minute))
+	if !a.QueueLess(young, old) {
+		t.Errorf("expected unit with higher priority to go first before aging")
+	}
+
+	fakeClock.SetTime(start.Add(30 * time.Minute))
+	if !a.QueueLess(old, young) {
+		t.Errorf("expected old unit to go first after aging")
+	}
+
+	fakeClock.SetTime(start.Add(24 * time.Hour))
+	if got := a.EffectivePriority(old, fakeClock.Now()); got != 110 {
+		t.Errorf("EffectivePriority() = %v, want the capped priority 110", got)
+	}
+}
+
+func makeQueueUnitInfo(name string, priority int32, timestamp time.Time) *framework.QueueUnitInfo {
+	info := framework.NewQueueUnitInfo(&v1alpha1.QueueUnit{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "ns",
+		},
+		Spec: v1alpha1.QueueUnitSpec{
+			Priority: &priority,
+		},
+	})
+	info.InitialAttemptTimestamp = timestamp
+	return info
+}
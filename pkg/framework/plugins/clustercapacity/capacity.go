@@ -0,0 +1,211 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package clustercapacity
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	resourcehelper "k8s.io/kubernetes/pkg/api/v1/resource"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+// nodeInfo is the capacity of a node and the requests of the pods bound to it
+type nodeInfo struct {
+	// allocatable is nil once the node is deleted
+	allocatable corev1.ResourceList
+	schedulable bool
+	requested   corev1.ResourceList
+}
+
+// podInfo is the requests of a pod bound to a node which is not terminated
+type podInfo struct {
+	nodeName string
+	// consumers are the keys of the consumers the pod may belong to
+	consumers []string
+	requests  corev1.ResourceList
+}
+
+func newClusterCapacity() *ClusterCapacity {
+	return &ClusterCapacity{
+		nodes:        make(map[string]*nodeInfo),
+		pods:         make(map[string]*podInfo),
+		consumerPods: make(map[string]sets.String),
+		quRecord:     make(map[types.UID]*framework.QueueUnitInfo),
+	}
+}
+
+// addEventHandlers keeps the requests per node up to date with the events of the
+// node and pod informers
+func (cc *ClusterCapacity) addEventHandlers(factory informers.SharedInformerFactory) {
+	factory.Core().V1().Nodes().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    cc.updateNode,
+		UpdateFunc: func(_, obj interface{}) { cc.updateNode(obj) },
+		DeleteFunc: cc.deleteNode,
+	})
+	factory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    cc.updatePod,
+		UpdateFunc: func(_, obj interface{}) { cc.updatePod(obj) },
+		DeleteFunc: cc.deletePod,
+	})
+}
+
+func (cc *ClusterCapacity) updateNode(obj interface{}) {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	cc.Lock()
+	defer cc.Unlock()
+
+	info := cc.nodeInfo(node.Name)
+	info.allocatable = node.Status.Allocatable.DeepCopy()
+	info.schedulable = utils.IsNodeSchedulable(node)
+}
+
+func (cc *ClusterCapacity) deleteNode(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	cc.Lock()
+	defer cc.Unlock()
+
+	info, exist := cc.nodes[node.Name]
+	if !exist {
+		return
+	}
+	// the requests of its pods are kept until the pods are deleted too
+	info.allocatable = nil
+	info.schedulable = false
+	if len(info.requested) == 0 {
+		delete(cc.nodes, node.Name)
+	}
+}
+
+func (cc *ClusterCapacity) updatePod(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	key := podKey(pod)
+	cc.Lock()
+	defer cc.Unlock()
+
+	cc.removePod(key)
+	if pod.Spec.NodeName == "" || utils.IsPodTerminated(pod) {
+		return
+	}
+	requests, _ := resourcehelper.PodRequestsAndLimits(pod)
+	requests[corev1.ResourcePods] = *resource.NewQuantity(1, resource.DecimalSI)
+	info := &podInfo{nodeName: pod.Spec.NodeName, consumers: consumerKeysOf(pod), requests: requests}
+	cc.pods[key] = info
+	node := cc.nodeInfo(info.nodeName)
+	for rName, rQuantity := range requests {
+		addQuantity(node.requested, rName, rQuantity)
+	}
+	for _, consumer := range info.consumers {
+		if _, exist := cc.consumerPods[consumer]; !exist {
+			cc.consumerPods[consumer] = sets.NewString()
+		}
+		cc.consumerPods[consumer].Insert(key)
+	}
+}
+
+func (cc *ClusterCapacity) deletePod(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	cc.Lock()
+	defer cc.Unlock()
+
+	cc.removePod(podKey(pod))
+}
+
+// removePod stops counting the requests of a pod, the caller must hold the lock
+func (cc *ClusterCapacity) removePod(key string) {
+	info, exist := cc.pods[key]
+	if !exist {
+		return
+	}
+	if node, exist := cc.nodes[info.nodeName]; exist {
+		for rName, rQuantity := range info.requests {
+			removeQuantity(node.requested, rName, rQuantity)
+		}
+		if node.allocatable == nil && len(node.requested) == 0 {
+			delete(cc.nodes, info.nodeName)
+		}
+	}
+	for _, consumer := range info.consumers {
+		cc.consumerPods[consumer].Delete(key)
+		if cc.consumerPods[consumer].Len() == 0 {
+			delete(cc.consumerPods, consumer)
+		}
+	}
+	delete(cc.pods, key)
+}
+
+// nodeInfo returns the aggregate of the given node, a pod may be bound to a node
+// before the node is known, the caller must hold the lock
+func (cc *ClusterCapacity) nodeInfo(name string) *nodeInfo {
+	info, exist := cc.nodes[name]
+	if !exist {
+		info = &nodeInfo{requested: make(corev1.ResourceList)}
+		cc.nodes[name] = info
+	}
+	return info
+}
+
+func podKey(pod *corev1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
+
+// consumerKeysOf returns the keys of the consumers a pod may belong to: the pod
+// itself and its owners
+func consumerKeysOf(pod *corev1.Pod) []string {
+	keys := []string{consumerKey(pod.Namespace, "Pod", pod.Name)}
+	for _, owner := range pod.OwnerReferences {
+		keys = append(keys, consumerKey(pod.Namespace, owner.Kind, owner.Name))
+	}
+	return keys
+}
+
+// consumerKeyOf returns the key of the consumer of a QueueUnit
+func consumerKeyOf(unit *v1alpha1.QueueUnit) string {
+	ref := unit.Spec.ConsumerRef
+	if ref == nil {
+		return ""
+	}
+	return consumerKey(utils.QueueUnitNamespace(unit), ref.Kind, ref.Name)
+}
+
+func consumerKey(namespace, kind, name string) string {
+	return namespace + "/" + kind + "/" + name
+}
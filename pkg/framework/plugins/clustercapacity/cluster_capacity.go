@@ -0,0 +1,269 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package clustercapacity
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	v1helper "k8s.io/kubernetes/pkg/apis/core/v1/helper"
+
+	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+// Name is the name of the plugin used in the plugin registry and configurations.
+const Name = "ClusterCapacity"
+
+const (
+	ErrInsufficientCapacityTemplate = "insufficient %s in the cluster, free %v, request %v"
+)
+
+// Args holds the arguments used to configure the ClusterCapacity plugin.
+type Args struct {
+	metav1.TypeMeta
+
+	// Disabled lets every unit pass, e.g. when the cluster is autoscaled.
+	Disabled bool
+}
+
+// DeepCopyObject implements runtime.Object.
+func (in *Args) DeepCopyObject() runtime.Object {
+	out := *in
+	return &out
+}
+
+// ClusterCapacity is a plugin that rejects the units whose requests exceed the
+// capacity left in the schedulable nodes of the cluster. The capacity is summed
+// over the nodes, a unit may still not fit if its pods do not fit in a single node.
+type ClusterCapacity struct {
+	sync.RWMutex
+	args Args
+	// nodes and pods aggregate the requests of the pods per node, they are kept up to
+	// date by the events of the informers
+	nodes map[string]*nodeInfo
+	pods  map[string]*podInfo
+	// consumerPods are the keys of the pods of each consumer
+	consumerPods map[string]sets.String
+	// quRecord is keyed by the UID of the dequeued units
+	quRecord map[types.UID]*framework.QueueUnitInfo
+}
+
+var _ framework.FilterPlugin = &ClusterCapacity{}
+var _ framework.ReservePlugin = &ClusterCapacity{}
+var _ framework.EnqueueExtensions = &ClusterCapacity{}
+var _ framework.ReservationLister = &ClusterCapacity{}
+
+// Name returns name of the plugin.
+func (cc *ClusterCapacity) Name() string {
+	return Name
+}
+
+// EventsToRegister returns the events which may free capacity in the cluster, none
+// when the plugin is disabled so that the nodes are not watched.
+func (cc *ClusterCapacity) EventsToRegister() []framework.ClusterEvent {
+	if cc.args.Disabled {
+		return nil
+	}
+	return []framework.ClusterEvent{framework.QueueUnitDeleted, framework.NodeUpdated, framework.PodTerminated}
+}
+
+// Filter returns Status with success if the capacity left in the cluster covers the
+// requests of the given QueueUnitInfo.
+func (cc *ClusterCapacity) Filter(ctx context.Context, qu *framework.QueueUnitInfo) *framework.Status {
+	if cc.args.Disabled {
+		return framework.NewStatus(framework.Success, "")
+	}
+
+	cc.RLock()
+	defer cc.RUnlock()
+
+	return cc.fit(qu)
+}
+
+// fit checks the requests of the given QueueUnitInfo against the capacity left in
+// the cluster, the caller must hold the lock
+func (cc *ClusterCapacity) fit(qu *framework.QueueUnitInfo) *framework.Status {
+	requests := nodeRequests(qu.Unit.Spec.Resource)
+	if len(requests) == 0 {
+		return framework.NewStatus(framework.Success, "")
+	}
+	free := cc.free()
+	for rName, rQuantity := range requests {
+		freeQuantity := free[rName]
+		if freeQuantity.Cmp(rQuantity) < 0 {
+			return framework.NewStatus(framework.Unschedulable, fmt.Sprintf(ErrInsufficientCapacityTemplate, rName, freeQuantity.String(), rQuantity.String()))
+		}
+	}
+	return framework.NewStatus(framework.Success, "")
+}
+
+// free returns the allocatable resource of the schedulable nodes minus the requests
+// of their pods and of the dequeued units whose pods are not bound yet, the caller
+// must hold the lock
+func (cc *ClusterCapacity) free() corev1.ResourceList {
+	free := make(corev1.ResourceList)
+	for _, node := range cc.nodes {
+		if !node.schedulable {
+			continue
+		}
+		for rName, rQuantity := range node.allocatable {
+			addQuantity(free, rName, rQuantity)
+		}
+		for rName, rQuantity := range node.requested {
+			subQuantity(free, rName, rQuantity)
+		}
+	}
+	for _, qu := range cc.quRecord {
+		for rName, rQuantity := range cc.outstandingRequests(qu) {
+			subQuantity(free, rName, rQuantity)
+		}
+	}
+	return free
+}
+
+// outstandingRequests returns the requests of a dequeued unit minus the requests of
+// the pods of its consumer bound to a node already, which are counted in the nodes,
+// the caller must hold the lock
+func (cc *ClusterCapacity) outstandingRequests(qu *framework.QueueUnitInfo) corev1.ResourceList {
+	requests := nodeRequests(qu.Unit.Spec.Resource)
+	for podKey := range cc.consumerPods[consumerKeyOf(qu.Unit)] {
+		for rName, rQuantity := range cc.pods[podKey].requests {
+			removeQuantity(requests, rName, rQuantity)
+		}
+	}
+	return requests
+}
+
+// Reserve counts the requests of the given QueueUnitInfo until its pods are bound,
+// it is a no-op if they are counted already. The capacity is checked again since
+// the queues of different namespaces are scheduled concurrently, but not for the
+// units which are dequeued already, e.g. when the reservations are rebuilt after a
+// restart.
+func (cc *ClusterCapacity) Reserve(ctx context.Context, qu *framework.QueueUnitInfo) *framework.Status {
+	cc.Lock()
+	defer cc.Unlock()
+
+	if _, exist := cc.quRecord[qu.Unit.UID]; exist {
+		return framework.NewStatus(framework.Success, "")
+	}
+	if !cc.args.Disabled && !utils.IsAdmitted(qu.Unit) {
+		if status := cc.fit(qu); status.Code() != framework.Success {
+			return status
+		}
+	}
+	cc.quRecord[qu.Unit.UID] = qu
+	return framework.NewStatus(framework.Success, "")
+}
+
+// Unreserve stops counting the requests of the given QueueUnitInfo
+func (cc *ClusterCapacity) Unreserve(ctx context.Context, qu *framework.QueueUnitInfo) {
+	cc.Lock()
+	defer cc.Unlock()
+
+	delete(cc.quRecord, qu.Unit.UID)
+}
+
+// Reservations returns the QueueUnitInfos whose requests are counted
+func (cc *ClusterCapacity) Reservations() []*framework.QueueUnitInfo {
+	cc.RLock()
+	defer cc.RUnlock()
+
+	reservations := make([]*framework.QueueUnitInfo, 0, len(cc.quRecord))
+	for _, qu := range cc.quRecord {
+		reservations = append(reservations, qu)
+	}
+	return reservations
+}
+
+// nodeRequests returns the requests of a QueueUnit of the resources allocatable by
+// the nodes, e.g. "cpu" or "requests.nvidia.com/gpu" as "nvidia.com/gpu". The
+// limits and the object counts are left out.
+func nodeRequests(resources corev1.ResourceList) corev1.ResourceList {
+	requests := make(corev1.ResourceList)
+	for rName, rQuantity := range resources {
+		name := strings.TrimPrefix(string(rName), corev1.DefaultResourceRequestsPrefix)
+		// "requests.cpu" takes precedence over "cpu" when a unit declares both
+		if name == string(rName) {
+			if _, exist := resources[corev1.DefaultResourceRequestsPrefix+rName]; exist {
+				continue
+			}
+		}
+		base := corev1.ResourceName(name)
+		switch {
+		case base == corev1.ResourceCPU || base == corev1.ResourceMemory || base == corev1.ResourceEphemeralStorage || base == corev1.ResourcePods:
+		case v1helper.IsExtendedResourceName(base) || v1helper.IsHugePageResourceName(base):
+		default:
+			continue
+		}
+		addQuantity(requests, base, rQuantity)
+	}
+	return requests
+}
+
+func addQuantity(list corev1.ResourceList, rName corev1.ResourceName, rQuantity resource.Quantity) {
+	sum := rQuantity.DeepCopy()
+	if val, exist := list[rName]; exist {
+		sum.Add(val)
+	}
+	list[rName] = sum
+}
+
+func subQuantity(list corev1.ResourceList, rName corev1.ResourceName, rQuantity resource.Quantity) {
+	val := list[rName].DeepCopy()
+	val.Sub(rQuantity)
+	list[rName] = val
+}
+
+// removeQuantity subtracts a quantity from a list, dropping the resources whose
+// quantity is not positive anymore
+func removeQuantity(list corev1.ResourceList, rName corev1.ResourceName, rQuantity resource.Quantity) {
+	val, exist := list[rName]
+	if !exist {
+		return
+	}
+	val.Sub(rQuantity)
+	if val.Sign() <= 0 {
+		delete(list, rName)
+		return
+	}
+	list[rName] = val
+}
+
+// New initializes a new plugin and returns it.
+func New(configuration runtime.Object, handle framework.Handle) (framework.Plugin, error) {
+	cc := newClusterCapacity()
+	if configuration != nil {
+		args, ok := configuration.(*Args)
+		if !ok {
+			return nil, fmt.Errorf("want args to be of type *clustercapacity.Args, got %T", configuration)
+		}
+		cc.args = *args
+	}
+	if !cc.args.Disabled {
+		cc.addEventHandlers(handle.SharedInformerFactory())
+	}
+	return cc, nil
+}
@@ -0,0 +1,191 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package clustercapacity
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/framework/runtime"
+)
+
+func TestClusterCapacity(t *testing.T) {
+	cc := newClusterCapacity()
+	cc.updateNode(makeNode("node1", "4", false, nil))
+	cc.updateNode(makeNode("cordoned", "8", true, nil))
+	cc.updateNode(makeNode("tainted", "8", false, []corev1.Taint{{Key: "dedicated", Effect: corev1.TaintEffectNoSchedule}}))
+	cc.updatePod(makePod("running", "node1", "1", corev1.PodRunning, ""))
+	cc.updatePod(makePod("succeeded", "node1", "2", corev1.PodSucceeded, ""))
+	cc.updatePod(makePod("other", "cordoned", "2", corev1.PodRunning, ""))
+
+	ctx := context.TODO()
+	qu := makeQueueUnitInfo("qu", "3")
+	if status := cc.Filter(ctx, qu); status.Code() != framework.Success {
+		t.Errorf("expected qu to fit, got %v", status.Message())
+	}
+	if status := cc.Filter(ctx, makeQueueUnitInfo("big", "4")); status.Code() != framework.Unschedulable {
+		t.Errorf("expected big to exceed the capacity of the schedulable nodes, got %v", status.Message())
+	}
+
+	// a dequeued unit counts until its pods are bound
+	dequeued := makeQueueUnitInfo("dequeued", "2")
+	cc.Reserve(ctx, dequeued)
+	if status := cc.Filter(ctx, qu); status.Code() != framework.Unschedulable {
+		t.Errorf("expected qu to exceed the capacity left, got %v", status.Message())
+	}
+	// a bound pending pod is counted in its node and not twice
+	cc.updatePod(makePod("dequeued-0", "node1", "2", corev1.PodPending, "dequeued"))
+	if status := cc.Filter(ctx, makeQueueUnitInfo("small", "1")); status.Code() != framework.Success {
+		t.Errorf("expected small to fit, got %v", status.Message())
+	}
+	if status := cc.Filter(ctx, makeQueueUnitInfo("medium", "2")); status.Code() != framework.Unschedulable {
+		t.Errorf("expected medium to exceed the capacity left, got %v", status.Message())
+	}
+	// the pods of the consumer which are not bound yet are still counted
+	cc.deletePod(makePod("dequeued-0", "node1", "2", corev1.PodPending, "dequeued"))
+	cc.updatePod(makePod("dequeued-0", "node1", "1", corev1.PodRunning, "dequeued"))
+	if status := cc.Filter(ctx, makeQueueUnitInfo("small", "1")); status.Code() != framework.Success {
+		t.Errorf("expected small to fit, got %v", status.Message())
+	}
+	if status := cc.Filter(ctx, makeQueueUnitInfo("medium", "2")); status.Code() != framework.Unschedulable {
+		t.Errorf("expected medium to exceed the capacity left, got %v", status.Message())
+	}
+
+	// units passing the filter concurrently are checked again when reserved
+	first, second := makeQueueUnitInfo("first", "1"), makeQueueUnitInfo("second", "1")
+	first.Unit.Status.Phase, second.Unit.Status.Phase = v1alpha1.Enqueued, v1alpha1.Enqueued
+	if status := cc.Reserve(ctx, first); status.Code() != framework.Success {
+		t.Errorf("expected first to be reserved, got %v", status.Message())
+	}
+	if status := cc.Reserve(ctx, second); status.Code() != framework.Unschedulable {
+		t.Errorf("expected second to exceed the capacity left, got %v", status.Message())
+	}
+	cc.Unreserve(ctx, first)
+
+	// a terminated pod releases its requests
+	cc.updatePod(makePod("dequeued-0", "node1", "1", corev1.PodSucceeded, "dequeued"))
+	cc.Unreserve(ctx, dequeued)
+	if status := cc.Filter(ctx, qu); status.Code() != framework.Success {
+		t.Errorf("expected qu to fit after unreserve, got %v", status.Message())
+	}
+
+	cc.deleteNode(makeNode("node1", "4", false, nil))
+	if status := cc.Filter(ctx, qu); status.Code() != framework.Unschedulable {
+		t.Errorf("expected qu to exceed the capacity left without node1, got %v", status.Message())
+	}
+}
+
+type fakeReservePlugin struct {
+	reserved map[types.UID]bool
+}
+
+func (p *fakeReservePlugin) Name() string {
+	return "Before"
+}
+
+func (p *fakeReservePlugin) Reserve(ctx context.Context, qu *framework.QueueUnitInfo) *framework.Status {
+	p.reserved[qu.Unit.UID] = true
+	return framework.NewStatus(framework.Success, "")
+}
+
+func (p *fakeReservePlugin) Unreserve(ctx context.Context, qu *framework.QueueUnitInfo) {
+	delete(p.reserved, qu.Unit.UID)
+}
+
+func TestReserveRollback(t *testing.T) {
+	cc := newClusterCapacity()
+	cc.updateNode(makeNode("node1", "2", false, nil))
+	fake := &fakeReservePlugin{reserved: make(map[types.UID]bool)}
+	// Before reserves ahead of ClusterCapacity, the plugins run in name order
+	fw, err := runtime.NewFramework(runtime.Registry{
+		"Before": func(_ k8sruntime.Object, _ framework.Handle) (framework.Plugin, error) {
+			return fake, nil
+		},
+		Name: func(_ k8sruntime.Object, _ framework.Handle) (framework.Plugin, error) {
+			return cc, nil
+		},
+	}, nil, "", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("new framework failed %v", err)
+	}
+
+	ctx := context.TODO()
+	first, second := makeQueueUnitInfo("first", "2"), makeQueueUnitInfo("second", "1")
+	first.Unit.Status.Phase, second.Unit.Status.Phase = v1alpha1.Enqueued, v1alpha1.Enqueued
+	if status := fw.RunReservePluginsReserve(ctx, first); status.Code() != framework.Success {
+		t.Fatalf("expected first to be reserved, got %v", status.Message())
+	}
+	if status := fw.RunReservePluginsReserve(ctx, second); status.Code() != framework.Unschedulable {
+		t.Fatalf("expected second to exceed the capacity left, got %v", status.Message())
+	}
+	if fake.reserved[second.Unit.UID] {
+		t.Errorf("expected the reservation of second by Before to be released")
+	}
+	if reservations := cc.Reservations(); len(reservations) != 1 || reservations[0].Unit.Name != "first" {
+		t.Errorf("expected only first to be counted, got %d reservations", len(reservations))
+	}
+}
+
+func makeNode(name, cpu string, unschedulable bool, taints []corev1.Taint) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       corev1.NodeSpec{Unschedulable: unschedulable, Taints: taints},
+		Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+			corev1.ResourceCPU:  resource.MustParse(cpu),
+			corev1.ResourcePods: resource.MustParse("110"),
+		}},
+	}
+}
+
+func makePod(name, nodeName, cpu string, phase corev1.PodPhase, owner string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"},
+		Spec: corev1.PodSpec{
+			NodeName: nodeName,
+			Containers: []corev1.Container{{
+				Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)}},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+	if owner != "" {
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: "Job", Name: owner}}
+	}
+	return pod
+}
+
+func makeQueueUnitInfo(name, cpu string) *framework.QueueUnitInfo {
+	return framework.NewQueueUnitInfo(&v1alpha1.QueueUnit{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", UID: types.UID(name)},
+		Spec: v1alpha1.QueueUnitSpec{
+			ConsumerRef: &corev1.ObjectReference{Kind: "Job", Namespace: "ns", Name: name},
+			Resource: corev1.ResourceList{
+				corev1.ResourceRequestsCPU: resource.MustParse(cpu),
+				"limits.cpu":               resource.MustParse("100"),
+			},
+		},
+		Status: v1alpha1.QueueUnitStatus{Phase: v1alpha1.Dequeued},
+	})
+}
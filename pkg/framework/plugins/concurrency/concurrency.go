@@ -0,0 +1,242 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package concurrency
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	listers "github.com/kube-queue/api/pkg/client/listers/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+// Name is the name of the plugin used in the plugin registry and configurations.
+const Name = "Concurrency"
+
+const (
+	ErrQueueLimitReachedTemplate = "queue %s reached its limit of %d dequeued units"
+	ErrUserLimitReachedTemplate  = "user %s reached its limit of %d dequeued units in queue %s"
+)
+
+// Args holds the arguments used to configure the Concurrency plugin.
+type Args struct {
+	metav1.TypeMeta
+
+	// MaxDequeuedUnits is the default limit of dequeued units per queue, 0 means unlimited.
+	MaxDequeuedUnits int
+	// MaxDequeuedUnitsPerUser is the default limit of dequeued units per user in a queue,
+	// 0 means unlimited.
+	MaxDequeuedUnitsPerUser int
+	// UserLabel is the label of QueueUnit identifying its submitter.
+	UserLabel string
+}
+
+// DeepCopyObject implements runtime.Object.
+func (in *Args) DeepCopyObject() runtime.Object {
+	out := *in
+	return &out
+}
+
+type record struct {
+	queue string
+	user  string
+}
+
+// Concurrency is a plugin that limits the number of concurrently dequeued units
+// per queue and per user.
+type Concurrency struct {
+	sync.RWMutex
+	args        Args
+	queueLister listers.QueueLister
+	// queueCount is the number of dequeued units per queue
+	queueCount map[string]int
+	// userCount is the number of dequeued units per queue and user
+	userCount map[record]int
+	// quRecord and units are keyed by the UID of the dequeued units
+	quRecord map[types.UID]record
+	units    map[types.UID]*framework.QueueUnitInfo
+}
+
+var _ framework.FilterPlugin = &Concurrency{}
+var _ framework.ReservePlugin = &Concurrency{}
+var _ framework.EnqueueExtensions = &Concurrency{}
+var _ framework.ReservationLister = &Concurrency{}
+
+// Name returns name of the plugin.
+func (c *Concurrency) Name() string {
+	return Name
+}
+
+// EventsToRegister returns the events which may lower the number of dequeued units
+// or raise the limits.
+func (c *Concurrency) EventsToRegister() []framework.ClusterEvent {
+	return []framework.ClusterEvent{framework.QueueUnitDeleted, framework.QueueUpdated}
+}
+
+// Filter returns Status with success if neither the queue nor the user of the
+// given QueueUnitInfo reached its limit.
+func (c *Concurrency) Filter(ctx context.Context, qu *framework.QueueUnitInfo) *framework.Status {
+	queueLimit, userLimit := c.limits(qu.QueueName)
+	r := c.recordOf(qu)
+
+	c.RLock()
+	defer c.RUnlock()
+
+	if queueLimit > 0 && c.queueCount[r.queue] >= queueLimit {
+		return framework.NewStatus(framework.Unschedulable, fmt.Sprintf(ErrQueueLimitReachedTemplate, r.queue, queueLimit))
+	}
+	if userLimit > 0 && r.user != "" && c.userCount[r] >= userLimit {
+		return framework.NewStatus(framework.Unschedulable, fmt.Sprintf(ErrUserLimitReachedTemplate, r.user, userLimit, r.queue))
+	}
+	return framework.NewStatus(framework.Success, "")
+}
+
+// Reserve counts the given QueueUnitInfo as dequeued, it is a no-op if it is counted
+// already
+func (c *Concurrency) Reserve(ctx context.Context, qu *framework.QueueUnitInfo) *framework.Status {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, exist := c.quRecord[qu.Unit.UID]; exist {
+		return framework.NewStatus(framework.Success, "")
+	}
+
+	r := c.recordOf(qu)
+	c.queueCount[r.queue]++
+	if r.user != "" {
+		c.userCount[r]++
+	}
+	c.quRecord[qu.Unit.UID] = r
+	c.units[qu.Unit.UID] = qu
+
+	return framework.NewStatus(framework.Success, "")
+}
+
+// Unreserve stops counting the given QueueUnitInfo as dequeued
+func (c *Concurrency) Unreserve(ctx context.Context, qu *framework.QueueUnitInfo) {
+	c.Lock()
+	defer c.Unlock()
+
+	r, exist := c.quRecord[qu.Unit.UID]
+	if !exist {
+		return
+	}
+
+	c.queueCount[r.queue]--
+	if c.queueCount[r.queue] <= 0 {
+		delete(c.queueCount, r.queue)
+	}
+	if r.user != "" {
+		c.userCount[r]--
+		if c.userCount[r] <= 0 {
+			delete(c.userCount, r)
+		}
+	}
+	delete(c.quRecord, qu.Unit.UID)
+	delete(c.units, qu.Unit.UID)
+}
+
+// Reservations returns the QueueUnitInfos counted as dequeued
+func (c *Concurrency) Reservations() []*framework.QueueUnitInfo {
+	c.RLock()
+	defer c.RUnlock()
+
+	reservations := make([]*framework.QueueUnitInfo, 0, len(c.units))
+	for _, qu := range c.units {
+		reservations = append(reservations, qu)
+	}
+	return reservations
+}
+
+func (c *Concurrency) recordOf(qu *framework.QueueUnitInfo) record {
+	r := record{queue: qu.QueueName}
+	if c.args.UserLabel != "" {
+		r.user = qu.Unit.Labels[c.args.UserLabel]
+	}
+	return r
+}
+
+// limits returns the limits of the given queue, the annotations of the Queue
+// take precedence over the arguments of the plugin.
+func (c *Concurrency) limits(queueName string) (int, int) {
+	queueLimit, userLimit := c.args.MaxDequeuedUnits, c.args.MaxDequeuedUnitsPerUser
+
+	q := c.getQueue(queueName)
+	if q == nil {
+		return queueLimit, userLimit
+	}
+	if val, exist := q.Annotations[utils.AnnotationMaxDequeuedUnits]; exist {
+		if limit, err := strconv.Atoi(val); err == nil {
+			queueLimit = limit
+		} else {
+			klog.Errorf("queue %s/%s has invalid %s: %v", q.Namespace, q.Name, utils.AnnotationMaxDequeuedUnits, err)
+		}
+	}
+	if val, exist := q.Annotations[utils.AnnotationMaxDequeuedUnitsPerUser]; exist {
+		if limit, err := strconv.Atoi(val); err == nil {
+			userLimit = limit
+		} else {
+			klog.Errorf("queue %s/%s has invalid %s: %v", q.Namespace, q.Name, utils.AnnotationMaxDequeuedUnitsPerUser, err)
+		}
+	}
+	return queueLimit, userLimit
+}
+
+// getQueue returns the Queue object of the given queue, keyed by namespace/name.
+func (c *Concurrency) getQueue(queueName string) *v1alpha1.Queue {
+	if c.queueLister == nil || queueName == "" {
+		return nil
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(queueName)
+	if err != nil {
+		return nil
+	}
+	q, err := c.queueLister.Queues(namespace).Get(name)
+	if err != nil {
+		return nil
+	}
+	return q
+}
+
+// New initializes a new plugin and returns it.
+func New(configuration runtime.Object, handle framework.Handle) (framework.Plugin, error) {
+	c := &Concurrency{
+		queueLister: handle.QueueInformerFactory().Scheduling().V1alpha1().Queues().Lister(),
+		queueCount:  make(map[string]int),
+		userCount:   make(map[record]int),
+		quRecord:    make(map[types.UID]record),
+		units:       make(map[types.UID]*framework.QueueUnitInfo),
+	}
+	if configuration != nil {
+		args, ok := configuration.(*Args)
+		if !ok {
+			return nil, fmt.Errorf("want args to be of type *concurrency.Args, got %T", configuration)
+		}
+		c.args = *args
+	}
+	return c, nil
+}
@@ -0,0 +1,98 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package concurrency
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	listers "github.com/kube-queue/api/pkg/client/listers/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+func TestConcurrency(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	indexer.Add(&v1alpha1.Queue{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "queue",
+			Namespace:   "ns",
+			Annotations: map[string]string{utils.AnnotationMaxDequeuedUnits: "3"},
+		},
+	})
+	c := &Concurrency{
+		args:        Args{MaxDequeuedUnits: 1, MaxDequeuedUnitsPerUser: 2, UserLabel: "user"},
+		queueLister: listers.NewQueueLister(indexer),
+		queueCount:  make(map[string]int),
+		userCount:   make(map[record]int),
+		quRecord:    make(map[types.UID]record),
+		units:       make(map[types.UID]*framework.QueueUnitInfo),
+	}
+
+	ctx := context.TODO()
+	alice1 := makeQueueUnitInfo("ns", "alice1", "alice")
+	alice2 := makeQueueUnitInfo("ns", "alice2", "alice")
+	alice3 := makeQueueUnitInfo("ns", "alice3", "alice")
+	bob1 := makeQueueUnitInfo("ns", "bob1", "bob")
+	other1 := makeQueueUnitInfo("other", "other1", "alice")
+	other2 := makeQueueUnitInfo("other", "other2", "bob")
+
+	for _, qu := range []*framework.QueueUnitInfo{alice1, alice2, other1} {
+		if status := c.Filter(ctx, qu); status.Code() != framework.Success {
+			t.Fatalf("expected %s to pass, got %v", qu.Name, status.Message())
+		}
+		c.Reserve(ctx, qu)
+	}
+	// reserving a unit twice counts it once
+	c.Reserve(ctx, alice1)
+	if status := c.Filter(ctx, alice3); status.Code() != framework.Unschedulable {
+		t.Errorf("expected alice to reach the user limit")
+	}
+	if status := c.Filter(ctx, bob1); status.Code() != framework.Success {
+		t.Errorf("expected bob1 to pass, got %v", status.Message())
+	}
+	if status := c.Filter(ctx, other2); status.Code() != framework.Unschedulable {
+		t.Errorf("expected queue other to reach the default queue limit")
+	}
+
+	c.Unreserve(ctx, alice1)
+	c.Unreserve(ctx, alice1)
+	if status := c.Filter(ctx, alice3); status.Code() != framework.Success {
+		t.Errorf("expected alice3 to pass after unreserve, got %v", status.Message())
+	}
+	if c.queueCount["ns/queue"] != 1 {
+		t.Errorf("expected 1 dequeued unit in queue ns/queue, got %d", c.queueCount["ns/queue"])
+	}
+}
+
+func makeQueueUnitInfo(queue, name, user string) *framework.QueueUnitInfo {
+	info := framework.NewQueueUnitInfo(&v1alpha1.QueueUnit{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: queue,
+			Labels:    map[string]string{"user": user},
+			UID:       types.UID(queue + "/" + name),
+		},
+	})
+	info.QueueName = queue + "/queue"
+	return info
+}
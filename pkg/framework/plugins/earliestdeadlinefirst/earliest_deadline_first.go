@@ -0,0 +1,52 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package earliestdeadlinefirst
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+// Name is the name of the plugin used in the plugin registry and configurations.
+const Name = "EarliestDeadlineFirst"
+
+// EarliestDeadlineFirst is a plugin that sorts units by deadline, earliest
+// first. Units without deadline go after the others.
+type EarliestDeadlineFirst struct{}
+
+var _ framework.QueueSortPlugin = &EarliestDeadlineFirst{}
+
+// Name returns name of the plugin.
+func (e *EarliestDeadlineFirst) Name() string {
+	return Name
+}
+
+func (e *EarliestDeadlineFirst) QueueLess(u1 *framework.QueueUnitInfo, u2 *framework.QueueUnitInfo) bool {
+	d1, ok1 := utils.Deadline(u1.Unit)
+	d2, ok2 := utils.Deadline(u2.Unit)
+	if ok1 != ok2 {
+		return ok1
+	}
+	return d1.Before(d2) || (d1.Equal(d2) && u1.InitialAttemptTimestamp.Before(u2.InitialAttemptTimestamp))
+}
+
+// New initializes a new plugin and returns it.
+func New(_ runtime.Object, handle framework.Handle) (framework.Plugin, error) {
+	return &EarliestDeadlineFirst{}, nil
+}
@@ -0,0 +1,70 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package earliestdeadlinefirst
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+func TestQueueLess(t *testing.T) {
+	start := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	urgent := makeQueueUnitInfo("urgent", "2021-10-01T13:00:00Z", start.Add(time.Minute))
+	relaxed := makeQueueUnitInfo("relaxed", "2021-10-02T13:00:00Z", start)
+	unknown := makeQueueUnitInfo("unknown", "", start)
+	sameUrgent := makeQueueUnitInfo("same-urgent", "2021-10-01T14:00:00+01:00", start.Add(2*time.Minute))
+
+	e := &EarliestDeadlineFirst{}
+	tests := []struct {
+		name string
+		u1   *framework.QueueUnitInfo
+		u2   *framework.QueueUnitInfo
+		want bool
+	}{
+		{"earlier deadline goes first", urgent, relaxed, true},
+		{"later deadline goes later", relaxed, urgent, false},
+		{"unit without deadline goes last", unknown, relaxed, false},
+		{"unit with deadline goes before unit without", relaxed, unknown, true},
+		{"same deadline falls back to timestamp", urgent, sameUrgent, true},
+	}
+	for _, tt := range tests {
+		if got := e.QueueLess(tt.u1, tt.u2); got != tt.want {
+			t.Errorf("%s: QueueLess() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func makeQueueUnitInfo(name, deadline string, timestamp time.Time) *framework.QueueUnitInfo {
+	unit := &v1alpha1.QueueUnit{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "ns",
+		},
+	}
+	if deadline != "" {
+		unit.Annotations = map[string]string{utils.AnnotationDeadline: deadline}
+	}
+	info := framework.NewQueueUnitInfo(unit)
+	info.InitialAttemptTimestamp = timestamp
+	return info
+}
@@ -17,15 +17,31 @@
 package priority
 
 import (
-	"github.com/kube-queue/kube-queue/pkg/framework"
+	"sync"
+
+	schedulingv1 "k8s.io/api/scheduling/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	schedulinglisters "k8s.io/client-go/listers/scheduling/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/kube-queue/kube-queue/pkg/framework"
 )
 
 // Name is the name of the plugin used in the plugin registry and configurations.
 const Name = "Priority"
 
 // Priority is a plugin that implements Priority plugin.
-type Priority struct{}
+type Priority struct {
+	sync.RWMutex
+	// pcLister resolves PriorityClassName into priority, it is backed by the
+	// informer cache so that the resolution never hits the API server.
+	pcLister schedulinglisters.PriorityClassLister
+	// globalDefaults are the values of the global default PriorityClasses keyed by
+	// name, kept from the informer events so that the global default is not searched
+	// on every comparison. The API server admits a single global default, the first
+	// name wins otherwise.
+	globalDefaults map[string]int32
+}
 
 var _ framework.MultiQueueSortPlugin = &Priority{}
 var _ framework.QueueSortPlugin = &Priority{}
@@ -36,30 +52,108 @@ func (p *Priority) Name() string {
 }
 
 func (p *Priority) MultiQueueLess(q1 *framework.QueueInfo, q2 *framework.QueueInfo) bool {
-	var p1, p2 int32 = 0, 0
-	if q1.Queue.Spec.Priority != nil {
-		p1 = *(q1.Queue.Spec.Priority)
-	}
-	if q2.Queue.Spec.Priority != nil {
-		p2 = *(q2.Queue.Spec.Priority)
-	}
+	p1 := p.QueuePriority(q1)
+	p2 := p.QueuePriority(q2)
 	return p1 > p2
 }
 
 func (p *Priority) QueueLess(u1 *framework.QueueUnitInfo, u2 *framework.QueueUnitInfo) bool {
-	var p1, p2 int32 = 0, 0
+	p1 := p.QueueUnitPriority(u1)
+	p2 := p.QueueUnitPriority(u2)
+	return (p1 > p2) || (p1 == p2 && u1.InitialAttemptTimestamp.Before(u2.InitialAttemptTimestamp))
+}
+
+// QueuePriority returns the priority of the given QueueInfo
+func (p *Priority) QueuePriority(q *framework.QueueInfo) int32 {
+	return p.resolve(q.Queue.Spec.Priority, q.Queue.Spec.PriorityClassName)
+}
 
-	if u1.Unit.Spec.Priority != nil {
-		p1 = *(u1.Unit.Spec.Priority)
+// QueueUnitPriority returns the priority of the given QueueUnitInfo
+func (p *Priority) QueueUnitPriority(u *framework.QueueUnitInfo) int32 {
+	return p.resolve(u.Unit.Spec.Priority, u.Unit.Spec.PriorityClassName)
+}
+
+// resolve returns the priority if it is set, otherwise the value of the
+// PriorityClass, otherwise the value of the global default PriorityClass.
+func (p *Priority) resolve(priority *int32, priorityClassName string) int32 {
+	if priority != nil {
+		return *priority
+	}
+	if priorityClassName == "" {
+		return p.globalDefault()
+	}
+	if p.pcLister == nil {
+		return 0
 	}
+	pc, err := p.pcLister.Get(priorityClassName)
+	if err != nil {
+		return 0
+	}
+	return pc.Value
+}
 
-	if u2.Unit.Spec.Priority != nil {
-		p2 = *(u2.Unit.Spec.Priority)
+// globalDefault returns the value of the global default PriorityClass, 0 if there is
+// none
+func (p *Priority) globalDefault() int32 {
+	p.RLock()
+	defer p.RUnlock()
+
+	name, value := "", int32(0)
+	for n, v := range p.globalDefaults {
+		if name == "" || n < name {
+			name, value = n, v
+		}
 	}
-	return (p1 > p2) || (p1 == p2 && u1.InitialAttemptTimestamp.Before(u2.InitialAttemptTimestamp))
+	return value
+}
+
+func (p *Priority) updatePriorityClass(obj interface{}) {
+	pc, ok := obj.(*schedulingv1.PriorityClass)
+	if !ok {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+
+	if pc.GlobalDefault {
+		p.globalDefaults[pc.Name] = pc.Value
+		return
+	}
+	delete(p.globalDefaults, pc.Name)
+}
+
+func (p *Priority) deletePriorityClass(obj interface{}) {
+	var pc *schedulingv1.PriorityClass
+	switch t := obj.(type) {
+	case *schedulingv1.PriorityClass:
+		pc = t
+	case cache.DeletedFinalStateUnknown:
+		var ok bool
+		if pc, ok = t.Obj.(*schedulingv1.PriorityClass); !ok {
+			return
+		}
+	default:
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+
+	delete(p.globalDefaults, pc.Name)
 }
 
 // New initializes a new plugin and returns it.
 func New(_ runtime.Object, handle framework.Handle) (framework.Plugin, error) {
-	return &Priority{}, nil
+	informer := handle.SharedInformerFactory().Scheduling().V1().PriorityClasses()
+	p := &Priority{
+		pcLister:       informer.Lister(),
+		globalDefaults: make(map[string]int32),
+	}
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: p.updatePriorityClass,
+		UpdateFunc: func(_, newObj interface{}) {
+			p.updatePriorityClass(newObj)
+		},
+		DeleteFunc: p.deletePriorityClass,
+	})
+	return p, nil
 }
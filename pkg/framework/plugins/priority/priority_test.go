@@ -19,7 +19,10 @@ package priority
 import (
 	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
 	"github.com/kube-queue/kube-queue/pkg/framework"
+	schedulingv1 "k8s.io/api/scheduling/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	schedulinglisters "k8s.io/client-go/listers/scheduling/v1"
+	"k8s.io/client-go/tools/cache"
 
 	"testing"
 )
@@ -52,6 +55,57 @@ func TestQueueLess(t *testing.T) {
 	}
 }
 
+func TestPriorityClass(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "high"}, Value: 1000})
+	p := &Priority{pcLister: schedulinglisters.NewPriorityClassLister(indexer), globalDefaults: make(map[string]int32)}
+
+	withClass := &framework.QueueUnitInfo{Unit: makeQueueUnit("qu1", 0)}
+	withClass.Unit.Spec.Priority = nil
+	withClass.Unit.Spec.PriorityClassName = "high"
+	withoutClass := &framework.QueueUnitInfo{Unit: makeQueueUnit("qu2", 0)}
+	withoutClass.Unit.Spec.Priority = nil
+	missingClass := &framework.QueueUnitInfo{Unit: makeQueueUnit("qu3", 0)}
+	missingClass.Unit.Spec.Priority = nil
+	missingClass.Unit.Spec.PriorityClassName = "missing"
+
+	if got := p.QueueUnitPriority(withClass); got != 1000 {
+		t.Errorf("QueueUnitPriority() = %v, want 1000", got)
+	}
+	if got := p.QueueUnitPriority(withoutClass); got != 0 {
+		t.Errorf("QueueUnitPriority() = %v, want 0", got)
+	}
+	if got := p.QueueUnitPriority(missingClass); got != 0 {
+		t.Errorf("QueueUnitPriority() = %v, want 0", got)
+	}
+	if got := p.QueueUnitPriority(&framework.QueueUnitInfo{Unit: makeQueueUnit("qu4", 5)}); got != 5 {
+		t.Errorf("QueueUnitPriority() = %v, want the explicit priority 5", got)
+	}
+
+	// the global default is kept from the informer events
+	defaultClass := &schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Value: 100, GlobalDefault: true}
+	p.updatePriorityClass(defaultClass)
+	if got := p.QueueUnitPriority(withoutClass); got != 100 {
+		t.Errorf("QueueUnitPriority() = %v, want the global default 100", got)
+	}
+	notDefault := defaultClass.DeepCopy()
+	notDefault.GlobalDefault = false
+	p.updatePriorityClass(notDefault)
+	if got := p.QueueUnitPriority(withoutClass); got != 0 {
+		t.Errorf("QueueUnitPriority() = %v, want 0 once the class is no longer the global default", got)
+	}
+	p.updatePriorityClass(defaultClass)
+	p.deletePriorityClass(cache.DeletedFinalStateUnknown{Key: "default", Obj: defaultClass})
+	if got := p.QueueUnitPriority(withoutClass); got != 0 {
+		t.Errorf("QueueUnitPriority() = %v, want 0 once the global default is deleted", got)
+	}
+
+	q := &framework.QueueInfo{Queue: &v1alpha1.Queue{Spec: v1alpha1.QueueSpec{PriorityClassName: "high"}}}
+	if got := p.QueuePriority(q); got != 1000 {
+		t.Errorf("QueuePriority() = %v, want 1000", got)
+	}
+}
+
 func makeQueueUnit(name string, priority int32) *v1alpha1.QueueUnit {
 	return &v1alpha1.QueueUnit{
 		ObjectMeta: metav1.ObjectMeta{
@@ -0,0 +1,395 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package queuequota
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/api/pkg/client/clientset/versioned"
+	listers "github.com/kube-queue/api/pkg/client/listers/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+// Name is the name of the plugin used in the plugin registry and configurations.
+const Name = "QueueQuota"
+
+const (
+	ErrQueueQuotaExceededTemplate = "insufficient resource left for %s in the quota of queue %s reserved %v/%v, request %v"
+)
+
+// Args holds the arguments used to configure the QueueQuota plugin.
+type Args struct {
+	metav1.TypeMeta
+
+	// Limits are the quotas of the queues keyed by namespace/name, for the queues
+	// without the quota annotation.
+	Limits map[string]corev1.ResourceList
+}
+
+// DeepCopyObject implements runtime.Object.
+func (in *Args) DeepCopyObject() runtime.Object {
+	out := &Args{TypeMeta: in.TypeMeta}
+	if in.Limits != nil {
+		out.Limits = make(map[string]corev1.ResourceList, len(in.Limits))
+		for queue, limits := range in.Limits {
+			out.Limits[queue] = limits.DeepCopy()
+		}
+	}
+	return out
+}
+
+// ReadLimitsFile reads the quotas of the queues from a JSON file mapping the
+// namespace/name of the queues to their limits, e.g. a mounted ConfigMap.
+func ReadLimitsFile(path string) (map[string]corev1.ResourceList, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	limits := make(map[string]corev1.ResourceList)
+	if err := json.Unmarshal(data, &limits); err != nil {
+		return nil, fmt.Errorf("parse queue quotas %s: %v", path, err)
+	}
+	return limits, nil
+}
+
+// QueueQuota is a plugin that limits the resource of the dequeued units of each
+// queue, independently of the ResourceQuotas of the namespaces.
+type QueueQuota struct {
+	sync.RWMutex
+	args        Args
+	queueLister listers.QueueLister
+	queueClient versioned.Interface
+	// reserved is the resource reserved per queue
+	reserved map[string]corev1.ResourceList
+	// quRecord is keyed by the UID of the units resource is reserved for
+	quRecord map[types.UID]*framework.QueueUnitInfo
+
+	// reports are the queues whose usage is to be reported, the reports of a queue
+	// are coalesced until it is reported. nil if the usage is not reported.
+	reports workqueue.Interface
+}
+
+var _ framework.FilterPlugin = &QueueQuota{}
+var _ framework.ReservePlugin = &QueueQuota{}
+var _ framework.EnqueueExtensions = &QueueQuota{}
+var _ framework.ReservationLister = &QueueQuota{}
+
+// Name returns name of the plugin.
+func (q *QueueQuota) Name() string {
+	return Name
+}
+
+// EventsToRegister returns the events which may free or raise the quota of a queue.
+func (q *QueueQuota) EventsToRegister() []framework.ClusterEvent {
+	return []framework.ClusterEvent{framework.QueueUnitDeleted, framework.QueueUpdated}
+}
+
+// Filter returns Status with success if there is enough resource left for the given
+// QueueUnitInfo in the quota of its queue.
+func (q *QueueQuota) Filter(ctx context.Context, qu *framework.QueueUnitInfo) *framework.Status {
+	limits := q.limits(qu.QueueName)
+
+	q.RLock()
+	defer q.RUnlock()
+
+	return q.fit(qu, limits)
+}
+
+// fit checks the given QueueUnitInfo against the limits of its queue, the caller
+// must hold the lock
+func (q *QueueQuota) fit(qu *framework.QueueUnitInfo, limits corev1.ResourceList) *framework.Status {
+	reserved := q.reserved[qu.QueueName]
+	for rName, rQuantity := range usage(qu.Unit.Spec.Resource) {
+		hardQuantity, found := limits[rName]
+		if !found {
+			continue
+		}
+		// the unit never fits until the quota is raised
+		code := framework.Unschedulable
+		if hardQuantity.Cmp(rQuantity) < 0 {
+			code = framework.UnschedulableAndUnresolvable
+		}
+		reservedQuantity := reserved[rName]
+		total := reservedQuantity.DeepCopy()
+		total.Add(rQuantity)
+		if hardQuantity.Cmp(total) < 0 {
+			return framework.NewStatus(code, fmt.Sprintf(ErrQueueQuotaExceededTemplate,
+				rName, qu.QueueName, reservedQuantity.String(), hardQuantity.String(), rQuantity.String()))
+		}
+	}
+	return framework.NewStatus(framework.Success, "")
+}
+
+// Reserve resource in the quota of the queue of the given QueueUnitInfo, it is a
+// no-op if resource is reserved for the unit already or if the unit has no queue.
+// Like for the ResourceQuota plugin, the resource left is checked again except for
+// the dequeued units.
+func (q *QueueQuota) Reserve(ctx context.Context, qu *framework.QueueUnitInfo) *framework.Status {
+	if qu.QueueName == "" {
+		klog.V(4).Infof("unit %s has no queue, no queue quota is charged", qu.Name)
+		return framework.NewStatus(framework.Success, "")
+	}
+	limits := q.limits(qu.QueueName)
+
+	q.Lock()
+	if _, exist := q.quRecord[qu.Unit.UID]; exist {
+		q.Unlock()
+		return framework.NewStatus(framework.Success, "")
+	}
+	if !utils.IsAdmitted(qu.Unit) {
+		if status := q.fit(qu, limits); status.Code() != framework.Success {
+			q.Unlock()
+			return status
+		}
+	}
+
+	reserved, exist := q.reserved[qu.QueueName]
+	if !exist {
+		reserved = make(corev1.ResourceList)
+	}
+	for rName, rQuantity := range usage(qu.Unit.Spec.Resource) {
+		addQuantity(reserved, rName, rQuantity)
+	}
+	q.reserved[qu.QueueName] = reserved
+	q.quRecord[qu.Unit.UID] = qu
+	q.Unlock()
+
+	q.reportUsage(qu.QueueName)
+	return framework.NewStatus(framework.Success, "")
+}
+
+// Unreserve resource in the quota of the queue of the given QueueUnitInfo, the
+// resource reserved is released even if the unit moved to another queue since.
+func (q *QueueQuota) Unreserve(ctx context.Context, qu *framework.QueueUnitInfo) {
+	q.Lock()
+	reservedQu, exist := q.quRecord[qu.Unit.UID]
+	if !exist {
+		q.Unlock()
+		return
+	}
+
+	reserved := q.reserved[reservedQu.QueueName]
+	for rName, rQuantity := range usage(reservedQu.Unit.Spec.Resource) {
+		val, exist := reserved[rName]
+		if !exist {
+			continue
+		}
+		val.Sub(rQuantity)
+		if val.Sign() <= 0 {
+			delete(reserved, rName)
+			continue
+		}
+		reserved[rName] = val
+	}
+	if len(reserved) == 0 {
+		delete(q.reserved, reservedQu.QueueName)
+	}
+	delete(q.quRecord, qu.Unit.UID)
+	q.Unlock()
+
+	q.reportUsage(reservedQu.QueueName)
+}
+
+// Reservations returns the QueueUnitInfos resource is reserved for
+func (q *QueueQuota) Reservations() []*framework.QueueUnitInfo {
+	q.RLock()
+	defer q.RUnlock()
+
+	reservations := make([]*framework.QueueUnitInfo, 0, len(q.quRecord))
+	for _, qu := range q.quRecord {
+		reservations = append(reservations, qu)
+	}
+	return reservations
+}
+
+// GetReserved returns the resource reserved in the quota of the given queue
+func (q *QueueQuota) GetReserved(queueName string) corev1.ResourceList {
+	q.RLock()
+	defer q.RUnlock()
+
+	return q.reserved[queueName].DeepCopy()
+}
+
+// reportUsage queues the report of the resource reserved in the quota of the queue,
+// the reports are sent off the scheduling cycle by runReporter.
+func (q *QueueQuota) reportUsage(queueName string) {
+	if q.reports == nil || queueName == "" {
+		return
+	}
+	q.reports.Add(queueName)
+}
+
+// runReporter reports the usage of the queued queues until the reports are shut down.
+func (q *QueueQuota) runReporter() {
+	for q.processNextReport(context.TODO()) {
+	}
+}
+
+func (q *QueueQuota) processNextReport(ctx context.Context) bool {
+	obj, shutdown := q.reports.Get()
+	if shutdown {
+		return false
+	}
+	defer q.reports.Done(obj)
+
+	q.syncUsage(ctx, obj.(string))
+	return true
+}
+
+// syncUsage sets the resource reserved in the quota of the queue in the annotations
+// of the Queue, the queues without quota are left untouched. A failed report is
+// retried by the next reservation of the queue.
+func (q *QueueQuota) syncUsage(ctx context.Context, queueName string) {
+	queueObj := q.getQueue(queueName)
+	if queueObj == nil {
+		return
+	}
+	_, reported := queueObj.Annotations[utils.AnnotationQueueQuotaUsed]
+	if len(q.limits(queueName)) == 0 && !reported {
+		return
+	}
+	reserved := q.GetReserved(queueName)
+	if reserved == nil {
+		reserved = make(corev1.ResourceList)
+	}
+	data, err := json.Marshal(reserved)
+	if err != nil {
+		klog.Errorf("marshal usage of queue %s error %v", queueName, err)
+		return
+	}
+	value := string(data)
+	if queueObj.Annotations[utils.AnnotationQueueQuotaUsed] == value {
+		return
+	}
+
+	newQueue, err := q.queueClient.SchedulingV1alpha1().Queues(queueObj.Namespace).Get(ctx, queueObj.Name, metav1.GetOptions{})
+	if err != nil {
+		klog.Errorf("get queue %v/%v error %v", queueObj.Namespace, queueObj.Name, err)
+		return
+	}
+	if newQueue.Annotations[utils.AnnotationQueueQuotaUsed] == value {
+		return
+	}
+	if newQueue.Annotations == nil {
+		newQueue.Annotations = make(map[string]string)
+	}
+	newQueue.Annotations[utils.AnnotationQueueQuotaUsed] = value
+	_, err = q.queueClient.SchedulingV1alpha1().Queues(queueObj.Namespace).Update(ctx, newQueue, metav1.UpdateOptions{})
+	if err != nil {
+		klog.Errorf("update quota usage of queue %v/%v error %v", queueObj.Namespace, queueObj.Name, err)
+	}
+}
+
+// limits returns the quota of the given queue, the annotation of the Queue takes
+// precedence over the arguments of the plugin.
+func (q *QueueQuota) limits(queueName string) corev1.ResourceList {
+	limits := q.args.Limits[queueName]
+
+	queueObj := q.getQueue(queueName)
+	if queueObj == nil {
+		return limits
+	}
+	val, exist := queueObj.Annotations[utils.AnnotationQueueQuota]
+	if !exist {
+		return limits
+	}
+	annotated := make(corev1.ResourceList)
+	if err := json.Unmarshal([]byte(val), &annotated); err != nil {
+		klog.Errorf("queue %s/%s has invalid %s: %v", queueObj.Namespace, queueObj.Name, utils.AnnotationQueueQuota, err)
+		return limits
+	}
+	return annotated
+}
+
+// getQueue returns the Queue object of the given queue, keyed by namespace/name.
+func (q *QueueQuota) getQueue(queueName string) *v1alpha1.Queue {
+	if q.queueLister == nil || queueName == "" {
+		return nil
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(queueName)
+	if err != nil {
+		return nil
+	}
+	queueObj, err := q.queueLister.Queues(namespace).Get(name)
+	if err != nil {
+		return nil
+	}
+	return queueObj
+}
+
+// usage returns the resources of a QueueUnit counted in the quota of its queue, a
+// request counts under the bare resource name, e.g. "requests.cpu" as "cpu".
+func usage(resources corev1.ResourceList) corev1.ResourceList {
+	used := make(corev1.ResourceList)
+	for rName, rQuantity := range resources {
+		name := strings.TrimPrefix(string(rName), corev1.DefaultResourceRequestsPrefix)
+		// "requests.cpu" takes precedence over "cpu" when a unit declares both
+		if name == string(rName) {
+			if _, exist := resources[corev1.DefaultResourceRequestsPrefix+rName]; exist {
+				continue
+			}
+		}
+		addQuantity(used, corev1.ResourceName(name), rQuantity)
+	}
+	return used
+}
+
+func addQuantity(list corev1.ResourceList, rName corev1.ResourceName, rQuantity resource.Quantity) {
+	sum := rQuantity.DeepCopy()
+	if val, exist := list[rName]; exist {
+		sum.Add(val)
+	}
+	list[rName] = sum
+}
+
+// New initializes a new plugin and returns it.
+func New(configuration runtime.Object, handle framework.Handle) (framework.Plugin, error) {
+	q := &QueueQuota{
+		queueLister: handle.QueueInformerFactory().Scheduling().V1alpha1().Queues().Lister(),
+		reserved:    make(map[string]corev1.ResourceList),
+		quRecord:    make(map[types.UID]*framework.QueueUnitInfo),
+	}
+	if client := handle.QueueUnitClient(); client != nil {
+		q.queueClient = client
+		q.reports = workqueue.NewNamed("queue-quota-usage")
+		go q.runReporter()
+	}
+	if configuration != nil {
+		args, ok := configuration.(*Args)
+		if !ok {
+			return nil, fmt.Errorf("want args to be of type *queuequota.Args, got %T", configuration)
+		}
+		q.args = *args
+	}
+	return q, nil
+}
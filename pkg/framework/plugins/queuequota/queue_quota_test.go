@@ -0,0 +1,218 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package queuequota
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/api/pkg/client/clientset/versioned/fake"
+	listers "github.com/kube-queue/api/pkg/client/listers/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/framework/runtime"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+func TestQueueQuota(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	indexer.Add(&v1alpha1.Queue{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "annotated",
+			Namespace:   "ns",
+			Annotations: map[string]string{utils.AnnotationQueueQuota: `{"cpu": "4"}`},
+		},
+	})
+	q := &QueueQuota{
+		args: Args{Limits: map[string]corev1.ResourceList{
+			"ns/annotated": {corev1.ResourceCPU: resource.MustParse("1")},
+			"ns/queue":     {corev1.ResourceCPU: resource.MustParse("3")},
+		}},
+		queueLister: listers.NewQueueLister(indexer),
+		reserved:    make(map[string]corev1.ResourceList),
+		quRecord:    make(map[types.UID]*framework.QueueUnitInfo),
+	}
+
+	ctx := context.TODO()
+	qu1 := makeQueueUnitInfo("ns/queue", "qu1", corev1.ResourceCPU, "2", v1alpha1.Enqueued)
+	qu2 := makeQueueUnitInfo("ns/queue", "qu2", corev1.ResourceRequestsCPU, "2", v1alpha1.Enqueued)
+	for i := 0; i < 2; i++ {
+		if status := q.Reserve(ctx, qu1); status.Code() != framework.Success {
+			t.Fatalf("expected qu1 to be reserved, got %v", status.Message())
+		}
+	}
+	// requests.cpu counts against the cpu quota
+	if status := q.Filter(ctx, qu2); status.Code() != framework.Unschedulable {
+		t.Errorf("expected qu2 to exceed the quota of ns/queue, got %v", status.Message())
+	}
+	big := makeQueueUnitInfo("ns/queue", "big", corev1.ResourceCPU, "5", v1alpha1.Enqueued)
+	if status := q.Filter(ctx, big); status.Code() != framework.UnschedulableAndUnresolvable {
+		t.Errorf("expected big to never fit in ns/queue, got %v", status.Message())
+	}
+
+	// the annotation takes precedence over the arguments
+	annotated := makeQueueUnitInfo("ns/annotated", "qu3", corev1.ResourceCPU, "3", v1alpha1.Enqueued)
+	if status := q.Reserve(ctx, annotated); status.Code() != framework.Success {
+		t.Errorf("expected qu3 to fit in ns/annotated, got %v", status.Message())
+	}
+	// the queues without quota are unlimited
+	unlimited := makeQueueUnitInfo("ns/other", "qu4", corev1.ResourceCPU, "100", v1alpha1.Enqueued)
+	if status := q.Filter(ctx, unlimited); status.Code() != framework.Success {
+		t.Errorf("expected qu4 to pass, got %v", status.Message())
+	}
+
+	q.Unreserve(ctx, qu1)
+	q.Unreserve(ctx, qu1)
+	if status := q.Filter(ctx, qu2); status.Code() != framework.Success {
+		t.Errorf("expected qu2 to pass after unreserve, got %v", status.Message())
+	}
+	if reserved := q.GetReserved("ns/queue"); len(reserved) != 0 {
+		t.Errorf("expected nothing reserved in ns/queue, got %v", reserved)
+	}
+	if reserved := q.GetReserved("ns/annotated"); reserved.Cpu().Cmp(resource.MustParse("3")) != 0 {
+		t.Errorf("expected 3 cpu reserved in ns/annotated, got %v", reserved)
+	}
+}
+
+type fakeReservePlugin struct {
+	reserved map[types.UID]bool
+}
+
+func (p *fakeReservePlugin) Name() string {
+	return "Fake"
+}
+
+func (p *fakeReservePlugin) Reserve(ctx context.Context, qu *framework.QueueUnitInfo) *framework.Status {
+	p.reserved[qu.Unit.UID] = true
+	return framework.NewStatus(framework.Success, "")
+}
+
+func (p *fakeReservePlugin) Unreserve(ctx context.Context, qu *framework.QueueUnitInfo) {
+	delete(p.reserved, qu.Unit.UID)
+}
+
+func TestReserveRollback(t *testing.T) {
+	q := &QueueQuota{
+		args: Args{Limits: map[string]corev1.ResourceList{
+			"ns/queue": {corev1.ResourceCPU: resource.MustParse("3")},
+		}},
+		queueLister: listers.NewQueueLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		reserved:    make(map[string]corev1.ResourceList),
+		quRecord:    make(map[types.UID]*framework.QueueUnitInfo),
+	}
+	fake := &fakeReservePlugin{reserved: make(map[types.UID]bool)}
+	// Fake reserves before QueueQuota
+	fw, err := runtime.NewFramework(runtime.Registry{
+		"Fake": func(_ k8sruntime.Object, _ framework.Handle) (framework.Plugin, error) {
+			return fake, nil
+		},
+		Name: func(_ k8sruntime.Object, _ framework.Handle) (framework.Plugin, error) {
+			return q, nil
+		},
+	}, nil, "", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("new framework failed %v", err)
+	}
+
+	ctx := context.TODO()
+	qu1 := makeQueueUnitInfo("ns/queue", "qu1", corev1.ResourceCPU, "2", v1alpha1.Enqueued)
+	if status := fw.RunReservePluginsReserve(ctx, qu1); status.Code() != framework.Success {
+		t.Fatalf("expected qu1 to be reserved, got %v", status.Message())
+	}
+	// qu2 passed the filters before qu1 was reserved, the queue quota rejects it
+	qu2 := makeQueueUnitInfo("ns/queue", "qu2", corev1.ResourceCPU, "2", v1alpha1.Enqueued)
+	if status := fw.RunReservePluginsReserve(ctx, qu2); status.Code() == framework.Success {
+		t.Fatalf("expected qu2 to exceed the quota of ns/queue")
+	}
+	if fake.reserved[qu2.Unit.UID] {
+		t.Errorf("expected the reservation of qu2 by Fake to be released")
+	}
+	if reservations := q.Reservations(); len(reservations) != 1 || reservations[0].Unit.Name != "qu1" {
+		t.Errorf("expected only qu1 to be reserved in the queue quota, got %d reservations", len(reservations))
+	}
+	if reserved := q.GetReserved("ns/queue"); reserved.Cpu().Cmp(resource.MustParse("2")) != 0 {
+		t.Errorf("expected 2 cpu reserved in ns/queue, got %v", reserved)
+	}
+}
+
+func TestReportUsage(t *testing.T) {
+	queueObj := &v1alpha1.Queue{ObjectMeta: metav1.ObjectMeta{Name: "queue", Namespace: "ns"}}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	indexer.Add(queueObj)
+	client := fake.NewSimpleClientset(queueObj.DeepCopy())
+	q := &QueueQuota{
+		args: Args{Limits: map[string]corev1.ResourceList{
+			"ns/queue": {corev1.ResourceCPU: resource.MustParse("3")},
+		}},
+		queueLister: listers.NewQueueLister(indexer),
+		queueClient: client,
+		reserved:    make(map[string]corev1.ResourceList),
+		quRecord:    make(map[types.UID]*framework.QueueUnitInfo),
+		reports:     workqueue.New(),
+	}
+	defer q.reports.ShutDown()
+
+	// the reservations only queue the report of their queue, coalesced
+	ctx := context.TODO()
+	for _, name := range []string{"qu1", "qu2"} {
+		if status := q.Reserve(ctx, makeQueueUnitInfo("ns/queue", name, corev1.ResourceCPU, "1", v1alpha1.Enqueued)); status.Code() != framework.Success {
+			t.Fatalf("expected %s to be reserved, got %v", name, status.Message())
+		}
+	}
+	// the units without queue are charged nowhere
+	orphan := makeQueueUnitInfo("", "qu3", corev1.ResourceCPU, "1", v1alpha1.Enqueued)
+	if status := q.Reserve(ctx, orphan); status.Code() != framework.Success {
+		t.Fatalf("expected qu3 to pass, got %v", status.Message())
+	}
+	if len(q.Reservations()) != 2 || len(q.reserved) != 1 {
+		t.Errorf("expected the unit without queue not to be reserved, got %d reservations in %d queues", len(q.Reservations()), len(q.reserved))
+	}
+	if q.reports.Len() != 1 {
+		t.Fatalf("expected 1 coalesced report, got %d", q.reports.Len())
+	}
+
+	if !q.processNextReport(ctx) {
+		t.Fatalf("expected the report to be processed")
+	}
+	got, err := client.SchedulingV1alpha1().Queues("ns").Get(ctx, "queue", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get queue failed %v", err)
+	}
+	if used := got.Annotations[utils.AnnotationQueueQuotaUsed]; used != `{"cpu":"2"}` {
+		t.Errorf("expected the usage of the queue to be reported, got %q", used)
+	}
+}
+
+func makeQueueUnitInfo(queue, name string, rName corev1.ResourceName, quantity string, phase v1alpha1.QueueUnitPhase) *framework.QueueUnitInfo {
+	info := framework.NewQueueUnitInfo(&v1alpha1.QueueUnit{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", UID: types.UID(name)},
+		Spec: v1alpha1.QueueUnitSpec{
+			Resource: corev1.ResourceList{rName: resource.MustParse(quantity)},
+		},
+		Status: v1alpha1.QueueUnitStatus{Phase: phase},
+	})
+	info.QueueName = queue
+	return info
+}
@@ -17,8 +17,16 @@
 package plugins
 
 import (
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/aging"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/clustercapacity"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/concurrency"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/earliestdeadlinefirst"
 	"github.com/kube-queue/kube-queue/pkg/framework/plugins/priority"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/queuequota"
 	"github.com/kube-queue/kube-queue/pkg/framework/plugins/resourcequota"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/shortestjobfirst"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/smallestresourcefirst"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/userfairness"
 	"github.com/kube-queue/kube-queue/pkg/framework/runtime"
 )
 
@@ -27,7 +35,15 @@ import (
 // through the WithFrameworkOutOfTreeRegistry option.
 func NewInTreeRegistry() runtime.Registry {
 	return runtime.Registry{
-		resourcequota.Name: resourcequota.New,
-		priority.Name:      priority.New,
+		resourcequota.Name:         resourcequota.New,
+		priority.Name:              priority.New,
+		concurrency.Name:           concurrency.New,
+		userfairness.Name:          userfairness.New,
+		aging.Name:                 aging.New,
+		shortestjobfirst.Name:      shortestjobfirst.New,
+		earliestdeadlinefirst.Name: earliestdeadlinefirst.New,
+		smallestresourcefirst.Name: smallestresourcefirst.New,
+		queuequota.Name:            queuequota.New,
+		clustercapacity.Name:       clustercapacity.New,
 	}
 }
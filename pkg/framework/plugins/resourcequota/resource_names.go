@@ -0,0 +1,92 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package resourcequota
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	resourcehelper "k8s.io/kubernetes/pkg/api/v1/resource"
+	v1helper "k8s.io/kubernetes/pkg/apis/core/v1/helper"
+)
+
+const (
+	// limitsPrefix is the prefix of the quota keys limiting the sum of the limits
+	limitsPrefix = "limits."
+	// countPrefix is the prefix of the quota keys limiting the number of objects
+	countPrefix = "count/"
+)
+
+// quotaUsage maps the resources of a QueueUnit onto the ResourceQuota keys they count
+// against. A resource of the unit is a request, e.g. "cpu" or "requests.cpu", or a
+// limit, e.g. "limits.cpu". A request of cpu, memory or ephemeral-storage counts
+// against both the bare and the "requests." key, a request of an extended resource or
+// of hugepages against the "requests." key only, like for pods. Other resources, e.g.
+// "pods" or "count/tfjobs.kubeflow.org", count against their own key.
+func quotaUsage(resources corev1.ResourceList) corev1.ResourceList {
+	usage := make(corev1.ResourceList)
+	for rName, rQuantity := range resources {
+		name := string(rName)
+		if strings.HasPrefix(name, limitsPrefix) || strings.HasPrefix(name, countPrefix) {
+			addQuantity(usage, rName, rQuantity)
+			continue
+		}
+		// "requests.cpu" takes precedence over "cpu" when a unit declares both
+		if _, exist := resources[corev1.DefaultResourceRequestsPrefix+rName]; exist {
+			continue
+		}
+		base := corev1.ResourceName(strings.TrimPrefix(name, corev1.DefaultResourceRequestsPrefix))
+		switch {
+		case base == corev1.ResourceCPU || base == corev1.ResourceMemory || base == corev1.ResourceEphemeralStorage:
+			addQuantity(usage, base, rQuantity)
+			addQuantity(usage, corev1.DefaultResourceRequestsPrefix+base, rQuantity)
+		case v1helper.IsExtendedResourceName(base) || v1helper.IsHugePageResourceName(base):
+			addQuantity(usage, corev1.DefaultResourceRequestsPrefix+base, rQuantity)
+		default:
+			addQuantity(usage, rName, rQuantity)
+		}
+	}
+	return usage
+}
+
+// podQuotaUsage returns the ResourceQuota keys a non-terminal pod counts against,
+// like the quota evaluator of the pods does
+func podQuotaUsage(pod *corev1.Pod) corev1.ResourceList {
+	requests, limits := resourcehelper.PodRequestsAndLimits(pod)
+	resources := corev1.ResourceList{
+		corev1.ResourcePods:                       *resource.NewQuantity(1, resource.DecimalSI),
+		corev1.ResourceName(countPrefix + "pods"): *resource.NewQuantity(1, resource.DecimalSI),
+	}
+	for rName, rQuantity := range requests {
+		resources[corev1.DefaultResourceRequestsPrefix+rName] = rQuantity
+	}
+	for rName, rQuantity := range limits {
+		if rName == corev1.ResourceCPU || rName == corev1.ResourceMemory || rName == corev1.ResourceEphemeralStorage {
+			resources[limitsPrefix+rName] = rQuantity
+		}
+	}
+	return quotaUsage(resources)
+}
+
+func addQuantity(list corev1.ResourceList, rName corev1.ResourceName, rQuantity resource.Quantity) {
+	sum := rQuantity.DeepCopy()
+	if val, exist := list[rName]; exist {
+		sum.Add(val)
+	}
+	list[rName] = sum
+}
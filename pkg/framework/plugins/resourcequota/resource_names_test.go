@@ -0,0 +1,69 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package resourcequota
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestQuotaUsage(t *testing.T) {
+	usage := quotaUsage(corev1.ResourceList{
+		"cpu":                       resource.MustParse("1"),
+		"requests.memory":           resource.MustParse("1Gi"),
+		"limits.cpu":                resource.MustParse("2"),
+		"limits.memory":             resource.MustParse("2Gi"),
+		"nvidia.com/gpu":            resource.MustParse("4"),
+		"requests.hugepages-2Mi":    resource.MustParse("4Mi"),
+		"pods":                      resource.MustParse("2"),
+		"count/tfjobs.kubeflow.org": resource.MustParse("1"),
+	})
+	expected := corev1.ResourceList{
+		"cpu":                       resource.MustParse("1"),
+		"requests.cpu":              resource.MustParse("1"),
+		"memory":                    resource.MustParse("1Gi"),
+		"requests.memory":           resource.MustParse("1Gi"),
+		"limits.cpu":                resource.MustParse("2"),
+		"limits.memory":             resource.MustParse("2Gi"),
+		"requests.nvidia.com/gpu":   resource.MustParse("4"),
+		"requests.hugepages-2Mi":    resource.MustParse("4Mi"),
+		"pods":                      resource.MustParse("2"),
+		"count/tfjobs.kubeflow.org": resource.MustParse("1"),
+	}
+	if len(usage) != len(expected) {
+		t.Errorf("expected %d quota keys, got %v", len(expected), usage)
+	}
+	for rName, want := range expected {
+		if got, ok := usage[rName]; !ok || got.Cmp(want) != 0 {
+			t.Errorf("%s: expected %v, got %v", rName, want.String(), got.String())
+		}
+	}
+
+	// a request declared with and without prefix counts once
+	usage = quotaUsage(corev1.ResourceList{
+		"cpu":          resource.MustParse("1"),
+		"requests.cpu": resource.MustParse("2"),
+	})
+	if got := usage["requests.cpu"]; got.Cmp(resource.MustParse("2")) != 0 {
+		t.Errorf("expected 2 requests.cpu, got %v", got.String())
+	}
+	if got := usage["cpu"]; got.Cmp(resource.MustParse("2")) != 0 {
+		t.Errorf("expected 2 cpu, got %v", got.String())
+	}
+}
@@ -22,10 +22,11 @@ import (
 	"sync"
 
 	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	clientcorev1 "k8s.io/client-go/listers/core/v1"
 )
 
@@ -39,111 +40,84 @@ const (
 	ErrResourceQuotaTypeNotFoundTemplate  = "resource type %s not found in resource quota %s"
 	ErrResourceQuotaInsufficientTemplate  = "insufficient resource left for %s in resource quota %s reserved %v/%v, request %v"
 	ErrQueueUnitAlreadyReservedTemplate   = "queue unit %s already reserved"
+	ErrResourceQuotaExceededTemplate      = "insufficient resource left for %s in resource quota %s used %v reserved %v/%v, request %v"
 )
 
 // ResourceQuota is a plugin that implements ResourceQuota filter.
 type ResourceQuota struct {
 	sync.RWMutex
-	reserved map[string]corev1.ResourceList
-	quRecord map[string]interface{}
+	// quRecord are the reserved QueueUnitInfos keyed by the UID of the units
+	quRecord map[types.UID]*framework.QueueUnitInfo
 	rqLister clientcorev1.ResourceQuotaLister
+	// podLister tells the part of the reservations counted in Status.Used already
+	podLister clientcorev1.PodLister
 }
 
 var _ framework.FilterPlugin = &ResourceQuota{}
+var _ framework.EnqueueExtensions = &ResourceQuota{}
+var _ framework.ReservationLister = &ResourceQuota{}
 
 // Name returns name of the plugin.
 func (rq *ResourceQuota) Name() string {
 	return Name
 }
 
+// EventsToRegister returns the events which may free resource quota.
+func (rq *ResourceQuota) EventsToRegister() []framework.ClusterEvent {
+	return []framework.ClusterEvent{framework.QueueUnitDeleted, framework.ResourceQuotaUpdated}
+}
+
 func QueueUnitToKey(qu *framework.QueueUnitInfo) string {
 	return fmt.Sprintf("%s/%s", qu.Unit.GetNamespace(), qu.Unit.GetName())
 }
 
-// Reserve resource for the given QueueUnitInfo
+// Reserve resource for the given QueueUnitInfo, it is a no-op if resource is reserved
+// for the unit already. The resource left is checked again since units of a namespace
+// may be scheduled concurrently from shared queues, but not for the units which are
+// dequeued already, e.g. when the reservations are rebuilt after a restart.
 func (rq *ResourceQuota) Reserve(ctx context.Context, qu *framework.QueueUnitInfo) *framework.Status {
 	rq.Lock()
 	defer rq.Unlock()
 
-	key := QueueUnitToKey(qu)
-	if _, exist := rq.quRecord[key]; exist {
-		return framework.NewStatus(framework.Error, fmt.Sprintf(ErrQueueUnitAlreadyReservedTemplate, key))
-	}
-
-	ns := qu.Unit.Namespace
-	reservedNS, exist := rq.reserved[ns]
-	if !exist {
-		reservedNS = make(corev1.ResourceList)
+	if _, exist := rq.quRecord[qu.Unit.UID]; exist {
+		return framework.NewStatus(framework.Success, "")
 	}
-
-	for rName, rQuantity := range qu.Unit.Spec.Resource {
-		val, exist := reservedNS[rName]
-		if exist {
-			rQuantity.Add(val)
+	if !utils.IsAdmitted(qu.Unit) {
+		if status := rq.fit(qu); status.Code() != framework.Success {
+			return status
 		}
-		reservedNS[rName] = rQuantity
 	}
 
-	rq.reserved[ns] = reservedNS
-	rq.quRecord[key] = nil
+	rq.quRecord[qu.Unit.UID] = qu
 
 	return framework.NewStatus(framework.Success, "")
 }
 
-// Unreserve resource for the given QueueUnitInfo
+// Unreserve resource for the given QueueUnitInfo, it is a no-op if no resource is
+// reserved for the unit. The resource reserved is released even if the resource of
+// the unit changed since.
 func (rq *ResourceQuota) Unreserve(ctx context.Context, qu *framework.QueueUnitInfo) {
 	rq.Lock()
 	defer rq.Unlock()
 
-	key := QueueUnitToKey(qu)
-	if _, exist := rq.quRecord[key]; !exist {
-		return
-	}
-
-	ns := qu.Unit.Namespace
-	reservedNS, exist := rq.reserved[ns]
-	if !exist {
-		return
-	}
-
-	for rName, rQuantity := range qu.Unit.Spec.Resource {
-		val, exist := reservedNS[rName]
-		if !exist {
-			continue
-		}
-		// resource quantity found
-		val.Sub(rQuantity)
-		if val.Sign() <= 0 {
-			delete(reservedNS, rName)
-			continue
-		}
-		reservedNS[rName] = val
-	}
-
-	rq.reserved[ns] = reservedNS
-	delete(rq.quRecord, key)
+	delete(rq.quRecord, qu.Unit.UID)
 }
 
-// GetReservedByResourceName returns reserved resource quantity if the ResourceName is found,
-// otherwise returns zero Quantity
-func (rq *ResourceQuota) GetReservedByResourceName(ns string, rName corev1.ResourceName) resource.Quantity {
+// Reservations returns the QueueUnitInfos resource is reserved for
+func (rq *ResourceQuota) Reservations() []*framework.QueueUnitInfo {
 	rq.RLock()
 	defer rq.RUnlock()
 
-	reservedNS, exist := rq.reserved[ns]
-	if !exist {
-		reservedNS = make(corev1.ResourceList)
-	}
-
-	val, exist := reservedNS[rName]
-	if exist {
-		return val
+	reservations := make([]*framework.QueueUnitInfo, 0, len(rq.quRecord))
+	for _, qu := range rq.quRecord {
+		reservations = append(reservations, qu)
 	}
-
-	return *resource.NewQuantity(0, resource.BinarySI)
+	return reservations
 }
 
-// SelectResourceQuota returns the proper resource quota for the given namespace
+// SelectResourceQuota returns the proper resource quota for the given namespace.
+//
+// Deprecated: the plugin evaluates all the resource quotas of the namespace.
 func SelectResourceQuota(rqs []*corev1.ResourceQuota, ns string) (*corev1.ResourceQuota, error) {
 	if len(rqs) == 0 {
 		return nil, fmt.Errorf(ErrNoResourceQuotaTemplate, 0, ns)
@@ -163,44 +137,129 @@ func SelectResourceQuota(rqs []*corev1.ResourceQuota, ns string) (*corev1.Resour
 
 // Filter returns Status with success if there are enough resource left for the given QueueUnitInfo
 func (rq *ResourceQuota) Filter(ctx context.Context, qu *framework.QueueUnitInfo) *framework.Status {
-	// TODO: maybe there is a nil when locating the namespace; validate this QueueUnit first
-	ns := qu.Unit.Spec.ConsumerRef.Namespace
+	rq.RLock()
+	defer rq.RUnlock()
+
+	return rq.fit(qu)
+}
+
+// fit returns Status with success if there are enough resource left for the given
+// QueueUnitInfo in every ResourceQuota of its namespace whose scopes it is in, the
+// caller must hold the lock
+func (rq *ResourceQuota) fit(qu *framework.QueueUnitInfo) *framework.Status {
+	ns := utils.QueueUnitNamespace(qu.Unit)
 
-	// Locate corresponding ResourceQuota
 	rqs, err := rq.rqLister.ResourceQuotas(ns).List(labels.Everything())
 	if err != nil {
 		return framework.NewStatus(framework.Error, err.Error())
 	}
-	basket, err := SelectResourceQuota(rqs, ns)
+	if len(rqs) == 0 {
+		return framework.NewStatus(framework.UnschedulableAndUnresolvable, fmt.Sprintf(ErrNoResourceQuotaTemplate, 0, ns))
+	}
+	outstanding, err := rq.outstandingReservations(ns)
 	if err != nil {
 		return framework.NewStatus(framework.Error, err.Error())
 	}
-	if basket.Spec.Hard == nil {
-		return framework.NewStatus(framework.Error, fmt.Sprintf(ErrResourceQuotaStatusHardNilTemplate, basket.GetName()))
-	}
 
-	// Check if there are enough resource quota left for this unit
-	for rName, rQuantity := range qu.Unit.Spec.Resource {
-		basketQuantity, found := basket.Spec.Hard[rName]
-		if !found {
+	usage := quotaUsage(qu.Unit.Spec.Resource)
+	for _, basket := range rqs {
+		if !matchesScopes(basket, qu.Unit) {
 			continue
 		}
-		reservedQuantity := rq.GetReservedByResourceName(ns, rName)
-		reservedQuantity.Add(rQuantity)
-		if basketQuantity.Cmp(reservedQuantity) < 0 {
-			return framework.NewStatus(framework.Error,
-				fmt.Sprintf(ErrResourceQuotaInsufficientTemplate, rName, basket.GetName(), reservedQuantity.Value(), basketQuantity.Value(), rQuantity.Value()))
+		// the part of the reservations whose pods do not exist yet is not in
+		// Status.Used, it is added to the usage of the other workloads
+		reserved := make(corev1.ResourceList)
+		for _, r := range outstanding {
+			if !matchesScopes(basket, r.qu.Unit) {
+				continue
+			}
+			for rName, rQuantity := range r.usage {
+				addQuantity(reserved, rName, rQuantity)
+			}
+		}
+
+		// Check if there are enough resource quota left for this unit, under every
+		// quota key its resources count against
+		for rName, rQuantity := range usage {
+			hardQuantity, found := basket.Spec.Hard[rName]
+			if !found {
+				continue
+			}
+			// the unit never fits until the hard limit is raised
+			code := framework.Unschedulable
+			if hardQuantity.Cmp(rQuantity) < 0 {
+				code = framework.UnschedulableAndUnresolvable
+			}
+			used := basket.Status.Used[rName]
+			reservedQuantity := reserved[rName]
+			total := used.DeepCopy()
+			total.Add(reservedQuantity)
+			total.Add(rQuantity)
+			if hardQuantity.Cmp(total) < 0 {
+				return framework.NewStatus(code, fmt.Sprintf(ErrResourceQuotaExceededTemplate,
+					rName, basket.GetName(), used.String(), reservedQuantity.String(), hardQuantity.String(), rQuantity.String()))
+			}
 		}
 	}
 
 	return framework.NewStatus(framework.Success, "")
 }
 
+// outstanding is the part of the reservation of a unit not counted in Status.Used
+type outstanding struct {
+	qu    *framework.QueueUnitInfo
+	usage corev1.ResourceList
+}
+
+// outstandingReservations returns the reservations of the namespace minus the usage
+// of the existing pods of their consumers, which Status.Used counts already, the
+// caller must hold the lock
+func (rq *ResourceQuota) outstandingReservations(ns string) ([]outstanding, error) {
+	var reserved []*framework.QueueUnitInfo
+	for _, qu := range rq.quRecord {
+		if utils.QueueUnitNamespace(qu.Unit) == ns {
+			reserved = append(reserved, qu)
+		}
+	}
+	if len(reserved) == 0 {
+		return nil, nil
+	}
+
+	pods, err := rq.podLister.Pods(ns).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]outstanding, 0, len(reserved))
+	for _, qu := range reserved {
+		used := make(corev1.ResourceList)
+		for _, pod := range utils.ConsumerPods(qu.Unit, pods) {
+			for rName, rQuantity := range podQuotaUsage(pod) {
+				addQuantity(used, rName, rQuantity)
+			}
+		}
+		usage := quotaUsage(qu.Unit.Spec.Resource)
+		for rName, rQuantity := range usage {
+			usedQuantity, exist := used[rName]
+			if !exist {
+				continue
+			}
+			rQuantity.Sub(usedQuantity)
+			if rQuantity.Sign() <= 0 {
+				delete(usage, rName)
+				continue
+			}
+			usage[rName] = rQuantity
+		}
+		result = append(result, outstanding{qu: qu, usage: usage})
+	}
+	return result, nil
+}
+
 // New initializes a new plugin and returns it.
 func New(_ runtime.Object, handle framework.Handle) (framework.Plugin, error) {
 	return &ResourceQuota{
-		rqLister: handle.SharedInformerFactory().Core().V1().ResourceQuotas().Lister(),
-		reserved: make(map[string]corev1.ResourceList),
-		quRecord: make(map[string]interface{}),
+		rqLister:  handle.SharedInformerFactory().Core().V1().ResourceQuotas().Lister(),
+		podLister: handle.SharedInformerFactory().Core().V1().Pods().Lister(),
+		quRecord:  make(map[types.UID]*framework.QueueUnitInfo),
 	}, nil
 }
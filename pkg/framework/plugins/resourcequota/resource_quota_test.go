@@ -0,0 +1,210 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package resourcequota
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientcorev1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/framework"
+)
+
+func TestReserveIsIdempotent(t *testing.T) {
+	rq, _ := newTestResourceQuota(&corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "ns", Namespace: "ns"},
+		Spec:       corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("3")}},
+	})
+
+	ctx := context.TODO()
+	qu1 := makeQueueUnitInfo("qu1", "uid1", "2", v1alpha1.Enqueued)
+	for i := 0; i < 2; i++ {
+		if status := rq.Reserve(ctx, qu1); status.Code() != framework.Success {
+			t.Fatalf("expected qu1 to be reserved, got %v", status.Message())
+		}
+	}
+	if got := len(rq.Reservations()); got != 1 {
+		t.Errorf("expected 1 reservation, got %d", got)
+	}
+
+	// a unit recreated with the same name is reserved separately
+	recreated := makeQueueUnitInfo("qu1", "uid2", "2", v1alpha1.Enqueued)
+	if status := rq.Reserve(ctx, recreated); status.Code() == framework.Success {
+		t.Errorf("expected the recreated unit to exceed the quota")
+	}
+	// dequeued units are reserved even if they exceed the quota
+	recreated.Unit.Status.Phase = v1alpha1.Dequeued
+	if status := rq.Reserve(ctx, recreated); status.Code() != framework.Success {
+		t.Errorf("expected the dequeued unit to be reserved, got %v", status.Message())
+	}
+
+	rq.Unreserve(ctx, qu1)
+	rq.Unreserve(ctx, qu1)
+	if got := rq.Reservations(); len(got) != 1 || got[0] != recreated {
+		t.Errorf("expected only the recreated unit to be reserved after unreserve, got %v", got)
+	}
+}
+
+func TestFilterMapsResourceNames(t *testing.T) {
+	rq, _ := newTestResourceQuota(&corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "ns", Namespace: "ns"},
+		Spec: corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{
+			"requests.nvidia.com/gpu": resource.MustParse("4"),
+			"limits.cpu":              resource.MustParse("4"),
+		}},
+	})
+
+	ctx := context.TODO()
+	qu := makeQueueUnitInfo("qu1", "uid1", "1", v1alpha1.Enqueued)
+	qu.Unit.Spec.Resource["nvidia.com/gpu"] = resource.MustParse("5")
+	if status := rq.Filter(ctx, qu); status.Code() != framework.UnschedulableAndUnresolvable {
+		t.Errorf("expected the gpu request to exceed requests.nvidia.com/gpu, got %v", status.Message())
+	}
+	qu.Unit.Spec.Resource["nvidia.com/gpu"] = resource.MustParse("2")
+	qu.Unit.Spec.Resource["limits.cpu"] = resource.MustParse("5")
+	if status := rq.Filter(ctx, qu); status.Code() != framework.UnschedulableAndUnresolvable {
+		t.Errorf("expected the cpu limit to exceed limits.cpu, got %v", status.Message())
+	}
+	qu.Unit.Spec.Resource["limits.cpu"] = resource.MustParse("2")
+	if status := rq.Filter(ctx, qu); status.Code() != framework.Success {
+		t.Errorf("expected the unit to fit, got %v", status.Message())
+	}
+}
+
+func TestFilterEvaluatesAllResourceQuotas(t *testing.T) {
+	rq, _ := newTestResourceQuota(
+		&corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: "ns"},
+			Spec:       corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")}},
+			Status:     corev1.ResourceQuotaStatus{Used: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}},
+		},
+		&corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "high", Namespace: "ns"},
+			Spec: corev1.ResourceQuotaSpec{
+				Hard: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+				ScopeSelector: &corev1.ScopeSelector{MatchExpressions: []corev1.ScopedResourceSelectorRequirement{{
+					ScopeName: corev1.ResourceQuotaScopePriorityClass,
+					Operator:  corev1.ScopeSelectorOpIn,
+					Values:    []string{"high"},
+				}}},
+			},
+		},
+		&corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "best-effort", Namespace: "ns"},
+			Spec: corev1.ResourceQuotaSpec{
+				Hard:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("0")},
+				Scopes: []corev1.ResourceQuotaScope{corev1.ResourceQuotaScopeBestEffort},
+			},
+		},
+	)
+
+	ctx := context.TODO()
+	qu := makeQueueUnitInfo("qu", "uid", "2", v1alpha1.Enqueued)
+	if status := rq.Filter(ctx, qu); status.Code() != framework.Success {
+		t.Errorf("expected the unit to fit, got %v", status.Message())
+	}
+	qu.Unit.Spec.PriorityClassName = "high"
+	if status := rq.Filter(ctx, qu); status.Code() != framework.UnschedulableAndUnresolvable {
+		t.Errorf("expected the unit to exceed the high quota, got %v", status.Message())
+	}
+}
+
+func TestFilterCountsOutstandingReservations(t *testing.T) {
+	compute := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: "ns"},
+		Spec:       corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")}},
+	}
+	rq, pods := newTestResourceQuota(compute)
+
+	ctx := context.TODO()
+	// the consumer of a dequeued unit without pods is not in Status.Used
+	dequeued := makeQueueUnitInfo("dequeued", "uid0", "2", v1alpha1.Dequeued)
+	dequeued.Unit.Spec.ConsumerRef.Kind = "Job"
+	dequeued.Unit.Spec.ConsumerRef.Name = "job"
+	rq.Reserve(ctx, dequeued)
+	if status := rq.Filter(ctx, makeQueueUnitInfo("qu", "uid", "2", v1alpha1.Enqueued)); status.Code() != framework.Success {
+		t.Errorf("expected the unit to fit, got %v", status.Message())
+	}
+	if status := rq.Filter(ctx, makeQueueUnitInfo("qu", "uid", "3", v1alpha1.Enqueued)); status.Code() != framework.Unschedulable {
+		t.Errorf("expected the unit to exceed the compute quota, got %v", status.Message())
+	}
+
+	// a pending pod is in Status.Used and must not be counted twice
+	pods.Add(makePod("job-0", "job", "2", corev1.PodPending))
+	compute.Status.Used = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")}
+	if status := rq.Filter(ctx, makeQueueUnitInfo("qu", "uid", "2", v1alpha1.Enqueued)); status.Code() != framework.Success {
+		t.Errorf("expected the unit to fit, got %v", status.Message())
+	}
+
+	// the pods of a consumer which do not exist yet are still counted
+	pods.Delete(makePod("job-0", "job", "2", corev1.PodPending))
+	pods.Add(makePod("job-0", "job", "1", corev1.PodRunning))
+	compute.Status.Used = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}
+	if status := rq.Filter(ctx, makeQueueUnitInfo("qu", "uid", "3", v1alpha1.Enqueued)); status.Code() != framework.Unschedulable {
+		t.Errorf("expected the unit to exceed the compute quota, got %v", status.Message())
+	}
+	if status := rq.Filter(ctx, makeQueueUnitInfo("qu", "uid", "2", v1alpha1.Enqueued)); status.Code() != framework.Success {
+		t.Errorf("expected the unit to fit, got %v", status.Message())
+	}
+}
+
+func makePod(name, owner, cpu string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "ns",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Job", Name: owner}},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)}},
+		}}},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
+
+func makeQueueUnitInfo(name, uid, cpu string, phase v1alpha1.QueueUnitPhase) *framework.QueueUnitInfo {
+	return framework.NewQueueUnitInfo(&v1alpha1.QueueUnit{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", UID: types.UID(uid)},
+		Spec: v1alpha1.QueueUnitSpec{
+			ConsumerRef: &corev1.ObjectReference{Namespace: "ns"},
+			Resource:    corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)},
+		},
+		Status: v1alpha1.QueueUnitStatus{Phase: phase},
+	})
+}
+
+// newTestResourceQuota returns the plugin listing the given quotas and the indexer
+// of the pods it lists
+func newTestResourceQuota(quotas ...*corev1.ResourceQuota) (*ResourceQuota, cache.Indexer) {
+	rqIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, quota := range quotas {
+		rqIndexer.Add(quota)
+	}
+	podIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	return &ResourceQuota{
+		rqLister:  clientcorev1.NewResourceQuotaLister(rqIndexer),
+		podLister: clientcorev1.NewPodLister(podIndexer),
+		quRecord:  make(map[types.UID]*framework.QueueUnitInfo),
+	}, podIndexer
+}
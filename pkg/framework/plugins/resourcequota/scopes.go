@@ -0,0 +1,102 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package resourcequota
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+)
+
+// matchesScopes checks if a QueueUnit is in the scopes of a ResourceQuota, both the
+// scopes and the scope selector of the quota are considered
+func matchesScopes(quota *corev1.ResourceQuota, unit *v1alpha1.QueueUnit) bool {
+	for _, scope := range quota.Spec.Scopes {
+		requirement := corev1.ScopedResourceSelectorRequirement{
+			ScopeName: scope,
+			Operator:  corev1.ScopeSelectorOpExists,
+		}
+		if !matchesScope(requirement, unit) {
+			return false
+		}
+	}
+	if quota.Spec.ScopeSelector != nil {
+		for _, requirement := range quota.Spec.ScopeSelector.MatchExpressions {
+			if !matchesScope(requirement, unit) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchesScope evaluates a single scope requirement against a QueueUnit the way the
+// quota admission evaluates it against the pods of the unit. A unit is never
+// terminating since it declares no active deadline.
+func matchesScope(requirement corev1.ScopedResourceSelectorRequirement, unit *v1alpha1.QueueUnit) bool {
+	switch requirement.ScopeName {
+	case corev1.ResourceQuotaScopeTerminating:
+		return false
+	case corev1.ResourceQuotaScopeNotTerminating:
+		return true
+	case corev1.ResourceQuotaScopeBestEffort:
+		return isBestEffort(unit)
+	case corev1.ResourceQuotaScopeNotBestEffort:
+		return !isBestEffort(unit)
+	case corev1.ResourceQuotaScopePriorityClass:
+		return matchesPriorityClass(requirement, unit.Spec.PriorityClassName)
+	}
+	return false
+}
+
+// matchesPriorityClass evaluates a PriorityClass scope requirement against the
+// PriorityClassName of a QueueUnit
+func matchesPriorityClass(requirement corev1.ScopedResourceSelectorRequirement, priorityClassName string) bool {
+	switch requirement.Operator {
+	case corev1.ScopeSelectorOpExists:
+		return priorityClassName != ""
+	case corev1.ScopeSelectorOpDoesNotExist:
+		return priorityClassName == ""
+	case corev1.ScopeSelectorOpIn:
+		return contains(requirement.Values, priorityClassName)
+	case corev1.ScopeSelectorOpNotIn:
+		return !contains(requirement.Values, priorityClassName)
+	}
+	return false
+}
+
+// isBestEffort checks if a QueueUnit requests and limits neither cpu nor memory
+func isBestEffort(unit *v1alpha1.QueueUnit) bool {
+	for rName := range unit.Spec.Resource {
+		base := strings.TrimPrefix(strings.TrimPrefix(string(rName), corev1.DefaultResourceRequestsPrefix), limitsPrefix)
+		if base == string(corev1.ResourceCPU) || base == string(corev1.ResourceMemory) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, val := range values {
+		if val == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package resourcequota
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestMatchesPriorityClass(t *testing.T) {
+	tests := []struct {
+		name              string
+		operator          corev1.ScopeSelectorOperator
+		values            []string
+		priorityClassName string
+		expected          bool
+	}{
+		{name: "exists with class", operator: corev1.ScopeSelectorOpExists, priorityClassName: "high", expected: true},
+		{name: "exists without class", operator: corev1.ScopeSelectorOpExists, expected: false},
+		{name: "does not exist with class", operator: corev1.ScopeSelectorOpDoesNotExist, priorityClassName: "high", expected: false},
+		{name: "does not exist without class", operator: corev1.ScopeSelectorOpDoesNotExist, expected: true},
+		{name: "in", operator: corev1.ScopeSelectorOpIn, values: []string{"high"}, priorityClassName: "high", expected: true},
+		{name: "not in", operator: corev1.ScopeSelectorOpNotIn, values: []string{"high"}, priorityClassName: "high", expected: false},
+	}
+	for _, test := range tests {
+		requirement := corev1.ScopedResourceSelectorRequirement{
+			ScopeName: corev1.ResourceQuotaScopePriorityClass,
+			Operator:  test.operator,
+			Values:    test.values,
+		}
+		if got := matchesPriorityClass(requirement, test.priorityClassName); got != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
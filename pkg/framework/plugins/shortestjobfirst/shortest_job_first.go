@@ -0,0 +1,52 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package shortestjobfirst
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+// Name is the name of the plugin used in the plugin registry and configurations.
+const Name = "ShortestJobFirst"
+
+// ShortestJobFirst is a plugin that sorts units by expected runtime, shortest
+// first. Units without expected runtime go after the others.
+type ShortestJobFirst struct{}
+
+var _ framework.QueueSortPlugin = &ShortestJobFirst{}
+
+// Name returns name of the plugin.
+func (s *ShortestJobFirst) Name() string {
+	return Name
+}
+
+func (s *ShortestJobFirst) QueueLess(u1 *framework.QueueUnitInfo, u2 *framework.QueueUnitInfo) bool {
+	r1, ok1 := utils.ExpectedRuntime(u1.Unit)
+	r2, ok2 := utils.ExpectedRuntime(u2.Unit)
+	if ok1 != ok2 {
+		return ok1
+	}
+	return (r1 < r2) || (r1 == r2 && u1.InitialAttemptTimestamp.Before(u2.InitialAttemptTimestamp))
+}
+
+// New initializes a new plugin and returns it.
+func New(_ runtime.Object, handle framework.Handle) (framework.Plugin, error) {
+	return &ShortestJobFirst{}, nil
+}
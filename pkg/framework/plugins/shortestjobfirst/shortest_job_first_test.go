@@ -0,0 +1,72 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package shortestjobfirst
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+func TestQueueLess(t *testing.T) {
+	start := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	short := makeQueueUnitInfo("short", "10m", start.Add(time.Minute))
+	long := makeQueueUnitInfo("long", "2h", start)
+	unknown := makeQueueUnitInfo("unknown", "", start)
+	invalid := makeQueueUnitInfo("invalid", "soon", start.Add(-time.Minute))
+	sameShort := makeQueueUnitInfo("same-short", "10m", start.Add(2*time.Minute))
+
+	s := &ShortestJobFirst{}
+	tests := []struct {
+		name string
+		u1   *framework.QueueUnitInfo
+		u2   *framework.QueueUnitInfo
+		want bool
+	}{
+		{"shorter runtime goes first", short, long, true},
+		{"longer runtime goes later", long, short, false},
+		{"unit without runtime goes last", unknown, long, false},
+		{"unit with runtime goes before unit without", long, unknown, true},
+		{"invalid runtime is treated as unknown", unknown, invalid, false},
+		{"same runtime falls back to timestamp", short, sameShort, true},
+	}
+	for _, tt := range tests {
+		if got := s.QueueLess(tt.u1, tt.u2); got != tt.want {
+			t.Errorf("%s: QueueLess() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func makeQueueUnitInfo(name, runtime string, timestamp time.Time) *framework.QueueUnitInfo {
+	unit := &v1alpha1.QueueUnit{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "ns",
+		},
+	}
+	if runtime != "" {
+		unit.Annotations = map[string]string{utils.AnnotationExpectedRuntime: runtime}
+	}
+	info := framework.NewQueueUnitInfo(unit)
+	info.InitialAttemptTimestamp = timestamp
+	return info
+}
@@ -0,0 +1,109 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package smallestresourcefirst
+
+import (
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	v1helper "k8s.io/kubernetes/pkg/apis/core/v1/helper"
+
+	"github.com/kube-queue/kube-queue/pkg/framework"
+)
+
+// Name is the name of the plugin used in the plugin registry and configurations.
+const Name = "SmallestResourceFirst"
+
+// SmallestResourceFirst is a plugin that sorts units by resource request,
+// smallest first. Requests are compared by cpu, then memory, then the other
+// resources in alphabetical order. A request declared the quota way, e.g.
+// "requests.cpu", counts as "cpu".
+type SmallestResourceFirst struct{}
+
+var _ framework.QueueSortPlugin = &SmallestResourceFirst{}
+
+// Name returns name of the plugin.
+func (s *SmallestResourceFirst) Name() string {
+	return Name
+}
+
+func (s *SmallestResourceFirst) QueueLess(u1 *framework.QueueUnitInfo, u2 *framework.QueueUnitInfo) bool {
+	r1 := requests(u1.Unit.Spec.Resource)
+	r2 := requests(u2.Unit.Spec.Resource)
+	for _, rName := range resourceNames(r1, r2) {
+		q1 := r1[rName]
+		q2 := r2[rName]
+		if cmp := q1.Cmp(q2); cmp != 0 {
+			return cmp < 0
+		}
+	}
+	return u1.InitialAttemptTimestamp.Before(u2.InitialAttemptTimestamp)
+}
+
+// resourceNames returns the names of resources in the given lists, cpu and memory
+// first, then the others in alphabetical order.
+func resourceNames(r1, r2 corev1.ResourceList) []corev1.ResourceName {
+	names := []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory}
+	others := make([]string, 0)
+	seen := make(map[corev1.ResourceName]bool)
+	for _, r := range []corev1.ResourceList{r1, r2} {
+		for rName := range r {
+			if rName == corev1.ResourceCPU || rName == corev1.ResourceMemory || seen[rName] {
+				continue
+			}
+			seen[rName] = true
+			others = append(others, string(rName))
+		}
+	}
+	sort.Strings(others)
+	for _, rName := range others {
+		names = append(names, corev1.ResourceName(rName))
+	}
+	return names
+}
+
+// requests returns the requests of a QueueUnit keyed by resource name, e.g.
+// "requests.nvidia.com/gpu" as "nvidia.com/gpu". The limits and the object counts
+// are left out.
+func requests(resources corev1.ResourceList) corev1.ResourceList {
+	requests := make(corev1.ResourceList)
+	for rName, rQuantity := range resources {
+		name := strings.TrimPrefix(string(rName), corev1.DefaultResourceRequestsPrefix)
+		// "requests.cpu" takes precedence over "cpu" when a unit declares both
+		if name == string(rName) {
+			if _, exist := resources[corev1.DefaultResourceRequestsPrefix+rName]; exist {
+				continue
+			}
+		}
+		base := corev1.ResourceName(name)
+		switch {
+		case base == corev1.ResourceCPU || base == corev1.ResourceMemory || base == corev1.ResourceEphemeralStorage:
+		case v1helper.IsExtendedResourceName(base) || v1helper.IsHugePageResourceName(base):
+		default:
+			continue
+		}
+		requests[base] = rQuantity
+	}
+	return requests
+}
+
+// New initializes a new plugin and returns it.
+func New(_ runtime.Object, handle framework.Handle) (framework.Plugin, error) {
+	return &SmallestResourceFirst{}, nil
+}
@@ -0,0 +1,96 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package smallestresourcefirst
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/framework"
+)
+
+func TestQueueLess(t *testing.T) {
+	start := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	small := makeQueueUnitInfo("small", corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("1"),
+		corev1.ResourceMemory: resource.MustParse("8Gi"),
+	}, start.Add(time.Minute))
+	large := makeQueueUnitInfo("large", corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("2"),
+		corev1.ResourceMemory: resource.MustParse("1Gi"),
+	}, start)
+	lessMemory := makeQueueUnitInfo("less-memory", corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("1000m"),
+		corev1.ResourceMemory: resource.MustParse("4Gi"),
+	}, start.Add(2*time.Minute))
+	gpu := makeQueueUnitInfo("gpu", corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("1"),
+		corev1.ResourceMemory: resource.MustParse("8Gi"),
+		"nvidia.com/gpu":      resource.MustParse("1"),
+	}, start)
+	sameSmall := makeQueueUnitInfo("same-small", small.Unit.Spec.Resource, start.Add(3*time.Minute))
+	quotaKeys := makeQueueUnitInfo("quota-keys", corev1.ResourceList{
+		corev1.ResourceRequestsCPU:    resource.MustParse("2"),
+		corev1.ResourceRequestsMemory: resource.MustParse("1Gi"),
+	}, start.Add(4*time.Minute))
+	withLimits := makeQueueUnitInfo("with-limits", corev1.ResourceList{
+		corev1.ResourceCPU:       resource.MustParse("2"),
+		corev1.ResourceMemory:    resource.MustParse("1Gi"),
+		corev1.ResourceLimitsCPU: resource.MustParse("4"),
+	}, start.Add(-time.Minute))
+
+	s := &SmallestResourceFirst{}
+	tests := []struct {
+		name string
+		u1   *framework.QueueUnitInfo
+		u2   *framework.QueueUnitInfo
+		want bool
+	}{
+		{"less cpu goes first", small, large, true},
+		{"more cpu goes later", large, small, false},
+		{"same cpu compares memory", lessMemory, small, true},
+		{"extended resources are compared last", small, gpu, true},
+		{"same resources fall back to timestamp", small, sameSmall, true},
+		{"requests keys count as resource names", small, quotaKeys, true},
+		{"requests keys are not size 0", quotaKeys, small, false},
+		{"limits are ignored", withLimits, large, true},
+	}
+	for _, tt := range tests {
+		if got := s.QueueLess(tt.u1, tt.u2); got != tt.want {
+			t.Errorf("%s: QueueLess() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func makeQueueUnitInfo(name string, res corev1.ResourceList, timestamp time.Time) *framework.QueueUnitInfo {
+	info := framework.NewQueueUnitInfo(&v1alpha1.QueueUnit{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "ns",
+		},
+		Spec: v1alpha1.QueueUnitSpec{
+			Resource: res,
+		},
+	})
+	info.InitialAttemptTimestamp = timestamp
+	return info
+}
@@ -0,0 +1,201 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package userfairness
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/priority"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+// Name is the name of the plugin used in the plugin registry and configurations.
+const Name = "UserFairness"
+
+// Args holds the arguments used to configure the UserFairness plugin.
+type Args struct {
+	metav1.TypeMeta
+
+	// UserLabel is the label of QueueUnit identifying its submitter. The creator
+	// recorded by the admission webhook is used when the label is not set.
+	UserLabel string
+}
+
+// DeepCopyObject implements runtime.Object.
+func (in *Args) DeepCopyObject() runtime.Object {
+	out := *in
+	return &out
+}
+
+type user struct {
+	queue string
+	name  string
+}
+
+// UserFairness is a plugin that interleaves the units of different users in
+// round-robin order among units of equal priority.
+//
+// Every unit gets a tag when it is added to its queue: one more than the last
+// tag of its user, but no less than the tag of the last dequeued unit of its
+// queue. Units are then ordered by priority, tag and timestamp. Tags never
+// change while the unit stays in its queue, so the ordering is stable for the
+// heap.
+type UserFairness struct {
+	sync.Mutex
+	args     Args
+	priority *priority.Priority
+	// tags is the tag of each queued unit
+	tags map[string]unitTag
+	// lastTag is the last tag assigned to the units of each user
+	lastTag map[user]uint64
+	// served is the tag of the last dequeued unit of each queue
+	served map[string]uint64
+	// reserved is the tag each queue was served up to before a queued unit moved it
+	// forward, so that it can be moved back when the unit is unreserved
+	reserved map[string]uint64
+}
+
+var _ framework.QueueSortPlugin = &UserFairness{}
+var _ framework.QueueUnitTracker = &UserFairness{}
+var _ framework.ReservePlugin = &UserFairness{}
+
+// unitTag is the tag of a unit in its queue
+type unitTag struct {
+	queue string
+	tag   uint64
+}
+
+// Name returns name of the plugin.
+func (f *UserFairness) Name() string {
+	return Name
+}
+
+func (f *UserFairness) QueueLess(u1 *framework.QueueUnitInfo, u2 *framework.QueueUnitInfo) bool {
+	p1 := f.priority.QueueUnitPriority(u1)
+	p2 := f.priority.QueueUnitPriority(u2)
+	if p1 != p2 {
+		return p1 > p2
+	}
+
+	f.Lock()
+	t1 := f.tags[u1.Name].tag
+	t2 := f.tags[u2.Name].tag
+	f.Unlock()
+	if t1 != t2 {
+		return t1 < t2
+	}
+	return u1.InitialAttemptTimestamp.Before(u2.InitialAttemptTimestamp)
+}
+
+// QueueUnitAdded tags the given QueueUnitInfo, it keeps the tag of a unit which is
+// in the queue already
+func (f *UserFairness) QueueUnitAdded(qu *framework.QueueUnitInfo) {
+	f.Lock()
+	defer f.Unlock()
+
+	if t, exist := f.tags[qu.Name]; exist && t.queue == qu.QueueName {
+		return
+	}
+
+	u := user{queue: qu.QueueName, name: f.userOf(qu)}
+	tag := f.lastTag[u]
+	if served := f.served[qu.QueueName]; served > tag {
+		tag = served
+	}
+	tag++
+	f.lastTag[u] = tag
+	f.tags[qu.Name] = unitTag{queue: qu.QueueName, tag: tag}
+}
+
+// QueueUnitDeleted forgets the tag of the given QueueUnitInfo
+func (f *UserFairness) QueueUnitDeleted(qu *framework.QueueUnitInfo) {
+	f.Lock()
+	defer f.Unlock()
+
+	if t, exist := f.tags[qu.Name]; exist && t.queue == qu.QueueName {
+		delete(f.tags, qu.Name)
+		delete(f.reserved, qu.Name)
+	}
+}
+
+// Reserve moves the queue of the given QueueUnitInfo forward to its tag
+func (f *UserFairness) Reserve(ctx context.Context, qu *framework.QueueUnitInfo) *framework.Status {
+	f.Lock()
+	defer f.Unlock()
+
+	if t, exist := f.tags[qu.Name]; exist && t.tag > f.served[t.queue] {
+		f.reserved[qu.Name] = f.served[t.queue]
+		f.served[t.queue] = t.tag
+	}
+	return framework.NewStatus(framework.Success, "")
+}
+
+// Unreserve moves the queue of the given QueueUnitInfo back if it is still in its
+// queue and no unit served after it moved the queue further. The unit keeps its tag
+// until it leaves its queue.
+func (f *UserFairness) Unreserve(ctx context.Context, qu *framework.QueueUnitInfo) {
+	f.Lock()
+	defer f.Unlock()
+
+	served, exist := f.reserved[qu.Name]
+	if !exist {
+		return
+	}
+	delete(f.reserved, qu.Name)
+	if t := f.tags[qu.Name]; f.served[t.queue] == t.tag {
+		f.served[t.queue] = served
+	}
+}
+
+// userOf returns the submitter of the given QueueUnitInfo
+func (f *UserFairness) userOf(qu *framework.QueueUnitInfo) string {
+	if f.args.UserLabel != "" {
+		if name, exist := qu.Unit.Labels[f.args.UserLabel]; exist {
+			return name
+		}
+	}
+	return qu.Unit.Annotations[utils.AnnotationCreator]
+}
+
+// New initializes a new plugin and returns it.
+func New(configuration runtime.Object, handle framework.Handle) (framework.Plugin, error) {
+	p, err := priority.New(nil, handle)
+	if err != nil {
+		return nil, err
+	}
+	f := &UserFairness{
+		priority: p.(*priority.Priority),
+		tags:     make(map[string]unitTag),
+		lastTag:  make(map[user]uint64),
+		served:   make(map[string]uint64),
+		reserved: make(map[string]uint64),
+	}
+	if configuration != nil {
+		args, ok := configuration.(*Args)
+		if !ok {
+			return nil, fmt.Errorf("want args to be of type *userfairness.Args, got %T", configuration)
+		}
+		f.args = *args
+	}
+	return f, nil
+}
@@ -0,0 +1,190 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package userfairness
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/priority"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+func TestRoundRobin(t *testing.T) {
+	f := newUserFairness()
+
+	start := time.Now()
+	var units []*framework.QueueUnitInfo
+	for i, name := range []string{"a1", "a2", "a3", "b1", "b2", "c1"} {
+		qu := makeQueueUnitInfo(name, 0, start.Add(time.Duration(i)*time.Second))
+		units = append(units, qu)
+		f.QueueUnitAdded(qu)
+	}
+
+	got := sortedNames(f, units)
+	want := []string{"a1", "b1", "c1", "a2", "b2", "a3"}
+	if !equal(got, want) {
+		t.Errorf("got order %v, want %v", got, want)
+	}
+
+	// serving a1, b1 and c1 moves the queue forward, a late user joins the
+	// next round instead of going first
+	f.Reserve(context.TODO(), units[0])
+	f.Reserve(context.TODO(), units[3])
+	f.Reserve(context.TODO(), units[5])
+	for _, i := range []int{0, 3, 5} {
+		f.QueueUnitDeleted(units[i])
+	}
+	late := makeQueueUnitInfo("d1", 0, start.Add(time.Minute))
+	f.QueueUnitAdded(late)
+	rest := []*framework.QueueUnitInfo{units[1], units[2], units[4], late}
+	got = sortedNames(f, rest)
+	want = []string{"a2", "b2", "d1", "a3"}
+	if !equal(got, want) {
+		t.Errorf("got order %v, want %v", got, want)
+	}
+}
+
+func TestArrivalOrderOfUser(t *testing.T) {
+	f := newUserFairness()
+
+	// the later unit carries an earlier timestamp, e.g. restored from its annotation,
+	// and is compared first, it still goes after the unit added before it
+	now := time.Now()
+	first := makeQueueUnitInfo("a1", 0, now)
+	second := makeQueueUnitInfo("a2", 0, now.Add(-time.Minute))
+	f.QueueUnitAdded(first)
+	f.QueueUnitAdded(second)
+	if f.QueueLess(second, first) || !f.QueueLess(first, second) {
+		t.Errorf("expected units of one user to keep their arrival order")
+	}
+
+	f.QueueUnitDeleted(first)
+	f.QueueUnitDeleted(second)
+	if len(f.tags) != 0 {
+		t.Errorf("expected tags of deleted units to be forgotten, got %v", f.tags)
+	}
+}
+
+func TestUnreserve(t *testing.T) {
+	f := newUserFairness()
+
+	start := time.Now()
+	a1 := makeQueueUnitInfo("a1", 0, start)
+	a2 := makeQueueUnitInfo("a2", 0, start.Add(time.Second))
+	b1 := makeQueueUnitInfo("b1", 0, start.Add(2*time.Second))
+	for _, qu := range []*framework.QueueUnitInfo{a1, a2, b1} {
+		f.QueueUnitAdded(qu)
+	}
+
+	// a1 fails to be dequeued after it was reserved, the queue is moved back, so
+	// that a unit added meanwhile does not skip the round of a1
+	f.Reserve(context.TODO(), a1)
+	c1 := makeQueueUnitInfo("c1", 0, start.Add(time.Minute))
+	f.Unreserve(context.TODO(), a1)
+	f.QueueUnitAdded(c1)
+	got := sortedNames(f, []*framework.QueueUnitInfo{a1, a2, b1, c1})
+	want := []string{"a1", "b1", "c1", "a2"}
+	if !equal(got, want) {
+		t.Errorf("got order %v, want %v", got, want)
+	}
+
+	// a unit unreserved after it left its queue does not move the queue back
+	f.Reserve(context.TODO(), a1)
+	f.QueueUnitDeleted(a1)
+	f.Unreserve(context.TODO(), a1)
+	if f.served["ns"] != 1 {
+		t.Errorf("expected queue to stay served up to 1, got %v", f.served["ns"])
+	}
+}
+
+func TestPriorityFirst(t *testing.T) {
+	f := newUserFairness()
+	now := time.Now()
+	low := makeQueueUnitInfo("a1", 0, now)
+	high := makeQueueUnitInfo("a2", 10, now.Add(time.Second))
+	if !f.QueueLess(high, low) {
+		t.Errorf("expected unit with higher priority to go first")
+	}
+}
+
+func TestCreatorAnnotation(t *testing.T) {
+	f := &UserFairness{}
+	qu := makeQueueUnitInfo("x1", 0, time.Now())
+	delete(qu.Unit.Labels, "user")
+	qu.Unit.Annotations = map[string]string{utils.AnnotationCreator: "alice"}
+	if got := f.userOf(qu); got != "alice" {
+		t.Errorf("userOf() = %v, want alice", got)
+	}
+}
+
+func newUserFairness() *UserFairness {
+	return &UserFairness{
+		args:     Args{UserLabel: "user"},
+		priority: &priority.Priority{},
+		tags:     make(map[string]unitTag),
+		lastTag:  make(map[user]uint64),
+		served:   make(map[string]uint64),
+		reserved: make(map[string]uint64),
+	}
+}
+
+func sortedNames(f *UserFairness, units []*framework.QueueUnitInfo) []string {
+	sorted := append([]*framework.QueueUnitInfo{}, units...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return f.QueueLess(sorted[i], sorted[j])
+	})
+	names := make([]string, 0, len(sorted))
+	for _, qu := range sorted {
+		names = append(names, qu.Unit.Name)
+	}
+	return names
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func makeQueueUnitInfo(name string, priority int32, timestamp time.Time) *framework.QueueUnitInfo {
+	info := framework.NewQueueUnitInfo(&v1alpha1.QueueUnit{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "ns",
+			Labels:    map[string]string{"user": name[:1]},
+		},
+		Spec: v1alpha1.QueueUnitSpec{
+			Priority: &priority,
+		},
+	})
+	info.QueueName = "ns"
+	info.InitialAttemptTimestamp = timestamp
+	return info
+}
@@ -18,22 +18,33 @@ package runtime
 
 import (
 	"context"
+	"sort"
+	"time"
 
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/informers"
+	"k8s.io/klog/v2"
 
 	"github.com/kube-queue/api/pkg/client/clientset/versioned"
+	"github.com/kube-queue/api/pkg/client/informers/externalversions"
 	"github.com/kube-queue/kube-queue/pkg/framework"
 )
 
 var _ framework.Framework = &frameworkImpl{}
 
 type frameworkImpl struct {
-	multiQueueSortPlugin   framework.MultiQueueSortPlugin
-	filterPlugins          []framework.FilterPlugin
-	queueSortPlugins       []framework.QueueSortPlugin
-	reservePlugins         []framework.ReservePlugin
+	multiQueueSortPlugin framework.MultiQueueSortPlugin
+	filterPlugins        []framework.FilterPlugin
+	queueSortPlugins     []framework.QueueSortPlugin
+	reservePlugins       []framework.ReservePlugin
+	// pluginEvents are the events registered by the filter plugins implementing
+	// EnqueueExtensions.
+	pluginEvents           map[string]map[framework.ClusterEvent]bool
 	kubeConfigPath         string
 	sharedInformersFactory informers.SharedInformerFactory
+	queueInformerFactory   externalversions.SharedInformerFactory
 	queueUnitClient        *versioned.Clientset
 }
 
@@ -50,10 +61,49 @@ func (f *frameworkImpl) QueueSortFuncMap() map[string]framework.QueueLessFunc {
 	return queueLessFuncMap
 }
 
+func (f *frameworkImpl) QueueSortRefreshInterval(pluginName string) time.Duration {
+	for _, plugin := range f.queueSortPlugins {
+		if plugin.Name() != pluginName {
+			continue
+		}
+		if p, ok := plugin.(framework.QueueSortRefreshPlugin); ok {
+			return p.RefreshInterval()
+		}
+	}
+	return 0
+}
+
+func (f *frameworkImpl) RunQueueUnitAdded(pluginName string, unit *framework.QueueUnitInfo) {
+	if t := f.queueUnitTracker(pluginName); t != nil {
+		t.QueueUnitAdded(unit)
+	}
+}
+
+func (f *frameworkImpl) RunQueueUnitDeleted(pluginName string, unit *framework.QueueUnitInfo) {
+	if t := f.queueUnitTracker(pluginName); t != nil {
+		t.QueueUnitDeleted(unit)
+	}
+}
+
+// queueUnitTracker returns the queue sort plugin of the given name if it tracks the
+// QueueUnits of its queues, nil otherwise.
+func (f *frameworkImpl) queueUnitTracker(pluginName string) framework.QueueUnitTracker {
+	for _, plugin := range f.queueSortPlugins {
+		if plugin.Name() != pluginName {
+			continue
+		}
+		if t, ok := plugin.(framework.QueueUnitTracker); ok {
+			return t
+		}
+	}
+	return nil
+}
+
 func (f *frameworkImpl) RunFilterPlugins(ctx context.Context, unit *framework.QueueUnitInfo) *framework.Status {
 	for _, pl := range f.filterPlugins {
 		pluginStatus := pl.Filter(ctx, unit)
 		if pluginStatus.Code() != framework.Success {
+			pluginStatus.SetFailedPlugin(pl.Name())
 			return pluginStatus
 		}
 	}
@@ -65,10 +115,17 @@ func (f *frameworkImpl) RunScorePlugins(ctx context.Context) (int64, bool) {
 	return 0, false
 }
 
+// RunReservePluginsReserve runs the Reserve of every reserve plugin. If one of them
+// fails, the plugins which reserved for the unit already are unreserved in reverse
+// order, so that no reservation is left behind for a unit which is not dequeued.
 func (f *frameworkImpl) RunReservePluginsReserve(ctx context.Context, unit *framework.QueueUnitInfo) *framework.Status {
-	for _, pl := range f.reservePlugins {
+	for i, pl := range f.reservePlugins {
 		pluginStatus := pl.Reserve(ctx, unit)
 		if pluginStatus.Code() != framework.Success {
+			pluginStatus.SetFailedPlugin(pl.Name())
+			for j := i - 1; j >= 0; j-- {
+				f.reservePlugins[j].Unreserve(ctx, unit)
+			}
 			return pluginStatus
 		}
 	}
@@ -82,10 +139,69 @@ func (f *frameworkImpl) RunReservePluginsUnreserve(ctx context.Context, unit *fr
 	}
 }
 
+func (f *frameworkImpl) IsEventRelevant(event framework.ClusterEvent, unschedulablePlugins sets.String) bool {
+	if unschedulablePlugins.Len() == 0 {
+		return true
+	}
+	for name := range unschedulablePlugins {
+		events, ok := f.pluginEvents[name]
+		if !ok || events[event] {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *frameworkImpl) IsEventRegistered(event framework.ClusterEvent) bool {
+	for _, events := range f.pluginEvents {
+		if events[event] {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *frameworkImpl) ReconcileReservations(ctx context.Context, dequeued map[string]*framework.QueueUnitInfo, confirm func(string, *framework.QueueUnitInfo) bool) (int, int) {
+	reserved, unreserved := 0, 0
+	for _, pl := range f.reservePlugins {
+		lister, ok := pl.(framework.ReservationLister)
+		if !ok {
+			continue
+		}
+		// a unit recreated with the same name is another unit
+		reservations := make(map[types.UID]bool)
+		for _, info := range lister.Reservations() {
+			reservations[info.Unit.UID] = true
+			if d, ok := dequeued[info.Name]; ok && d.Unit.UID == info.Unit.UID || !confirm(pl.Name(), info) {
+				continue
+			}
+			klog.Infof("plugin %s holds a reservation for %s which is not dequeued, unreserve it", pl.Name(), info.Name)
+			pl.Unreserve(ctx, info)
+			unreserved++
+		}
+		for key, info := range dequeued {
+			if reservations[info.Unit.UID] || !confirm(pl.Name(), info) {
+				continue
+			}
+			klog.Infof("plugin %s holds no reservation for dequeued %s, reserve it", pl.Name(), key)
+			if status := pl.Reserve(ctx, info); status.Code() != framework.Success {
+				klog.Errorf("plugin %s reserve %s fail %v", pl.Name(), key, status.Message())
+				continue
+			}
+			reserved++
+		}
+	}
+	return reserved, unreserved
+}
+
 func (f *frameworkImpl) SharedInformerFactory() informers.SharedInformerFactory {
 	return f.sharedInformersFactory
 }
 
+func (f *frameworkImpl) QueueInformerFactory() externalversions.SharedInformerFactory {
+	return f.queueInformerFactory
+}
+
 func (f *frameworkImpl) KubeConfigPath() string {
 	return f.kubeConfigPath
 }
@@ -94,23 +210,36 @@ func (f *frameworkImpl) QueueUnitClient() *versioned.Clientset {
 	return f.queueUnitClient
 }
 
-func NewFramework(r Registry, kubeConfigPath string,
+// NewFramework initializes all the plugins of the registry, args holds the
+// configuration of the plugins keyed by plugin name. The plugins of each extension
+// point run in the order of their names.
+func NewFramework(r Registry, args map[string]runtime.Object, kubeConfigPath string,
 	informersFactory informers.SharedInformerFactory,
+	queueInformerFactory externalversions.SharedInformerFactory,
 	queueUnitClient *versioned.Clientset,
 ) (framework.Framework, error) {
 	filterPlugins := make([]framework.FilterPlugin, 0)
 	queueSortPlugins := make([]framework.QueueSortPlugin, 0)
 	reservePlugins := make([]framework.ReservePlugin, 0)
+	pluginEvents := make(map[string]map[framework.ClusterEvent]bool)
 	var multiQueueSortPlugin framework.MultiQueueSortPlugin
 
 	f := &frameworkImpl{
 		kubeConfigPath:         kubeConfigPath,
 		sharedInformersFactory: informersFactory,
+		queueInformerFactory:   queueInformerFactory,
 		queueUnitClient:        queueUnitClient,
 	}
 
-	for _, factory := range r {
-		p, err := factory(nil, f)
+	// the plugins are run in the order of their names, not in the random order of
+	// the registry
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p, err := r[name](args[name], f)
 		if err != nil {
 			return nil, err
 		}
@@ -123,6 +252,13 @@ func NewFramework(r Registry, kubeConfigPath string,
 		if i, ok := p.(framework.FilterPlugin); ok {
 			filterPlugins = append(filterPlugins, i)
 		}
+		if i, ok := p.(framework.EnqueueExtensions); ok {
+			events := make(map[framework.ClusterEvent]bool)
+			for _, event := range i.EventsToRegister() {
+				events[event] = true
+			}
+			pluginEvents[i.Name()] = events
+		}
 		if i, ok := p.(framework.ReservePlugin); ok {
 			reservePlugins = append(reservePlugins, i)
 		}
@@ -132,6 +268,7 @@ func NewFramework(r Registry, kubeConfigPath string,
 	f.reservePlugins = reservePlugins
 	f.multiQueueSortPlugin = multiQueueSortPlugin
 	f.filterPlugins = filterPlugins
+	f.pluginEvents = pluginEvents
 
 	return f, nil
 }
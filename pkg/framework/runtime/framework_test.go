@@ -0,0 +1,106 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package runtime
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/framework"
+)
+
+type fakeReservePlugin struct {
+	name     string
+	fail     bool
+	reserved map[types.UID]bool
+}
+
+func (p *fakeReservePlugin) Name() string {
+	return p.name
+}
+
+func (p *fakeReservePlugin) Reserve(ctx context.Context, qu *framework.QueueUnitInfo) *framework.Status {
+	if p.fail {
+		return framework.NewStatus(framework.Unschedulable, "no room")
+	}
+	p.reserved[qu.Unit.UID] = true
+	return framework.NewStatus(framework.Success, "")
+}
+
+func (p *fakeReservePlugin) Unreserve(ctx context.Context, qu *framework.QueueUnitInfo) {
+	delete(p.reserved, qu.Unit.UID)
+}
+
+func TestRunReservePluginsReserve(t *testing.T) {
+	first := &fakeReservePlugin{name: "First", reserved: make(map[types.UID]bool)}
+	second := &fakeReservePlugin{name: "Second", fail: true, reserved: make(map[types.UID]bool)}
+	third := &fakeReservePlugin{name: "Third", reserved: make(map[types.UID]bool)}
+	f := &frameworkImpl{reservePlugins: []framework.ReservePlugin{first, second, third}}
+
+	info := framework.NewQueueUnitInfo(&v1alpha1.QueueUnit{
+		ObjectMeta: metav1.ObjectMeta{Name: "unit", Namespace: "ns", UID: "uid"},
+	})
+	status := f.RunReservePluginsReserve(context.TODO(), info)
+	if status.Code() != framework.Unschedulable {
+		t.Fatalf("expected reserve to fail, got %v", status.Code())
+	}
+	if status.FailedPlugin() != "Second" {
+		t.Errorf("expected Second to be the failed plugin, got %q", status.FailedPlugin())
+	}
+	for _, pl := range []*fakeReservePlugin{first, second, third} {
+		if len(pl.reserved) != 0 {
+			t.Errorf("expected %v to hold no reservation, got %v", pl.name, pl.reserved)
+		}
+	}
+
+	second.fail = false
+	if status := f.RunReservePluginsReserve(context.TODO(), info); status.Code() != framework.Success {
+		t.Fatalf("expected reserve to succeed, got %v", status.Message())
+	}
+	for _, pl := range []*fakeReservePlugin{first, second, third} {
+		if !pl.reserved[info.Unit.UID] {
+			t.Errorf("expected %v to hold a reservation", pl.name)
+		}
+	}
+}
+
+func TestNewFrameworkPluginOrder(t *testing.T) {
+	r := make(Registry)
+	for _, name := range []string{"C", "A", "D", "B"} {
+		name := name
+		r[name] = func(_ runtime.Object, _ framework.Handle) (framework.Plugin, error) {
+			return &fakeReservePlugin{name: name, reserved: make(map[types.UID]bool)}, nil
+		}
+	}
+	fw, err := NewFramework(r, nil, "", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("new framework failed %v", err)
+	}
+	var got []string
+	for _, pl := range fw.(*frameworkImpl).reservePlugins {
+		got = append(got, pl.Name())
+	}
+	if want := []string{"A", "B", "C", "D"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got plugins %v, want %v", got, want)
+	}
+}
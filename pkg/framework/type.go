@@ -19,13 +19,16 @@ package framework
 import (
 	"time"
 
+	"k8s.io/apimachinery/pkg/util/sets"
+
 	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/utils"
 )
 
 // QueueInfo is a Queue wrapper with additional information related to the Queue
 type QueueInfo struct {
-	// Name is namespace
-	Name string
+	// Name is namespace + "/" + name
+	Name  string
 	Queue *v1alpha1.Queue
 }
 
@@ -35,29 +38,46 @@ type QueueUnitInfo struct {
 	// Name is namespace + "/" + name
 	Name string
 	Unit *v1alpha1.QueueUnit
+	// QueueName is the name of the queue the QueueUnit belongs to, namespace + "/" + name.
+	QueueName string
 	// The time QueueUnit added to the scheduling queue.
 	Timestamp time.Time
 	// Number of schedule attempts before successfully scheduled.
 	Attempts int
 	// The time when the QueueUnit is added to the queue for the first time.
 	InitialAttemptTimestamp time.Time
+	// BlockedSince is the time the QueueUnit started blocking the head of a
+	// backfill or blocking queue, zero if it is not blocked.
+	BlockedSince time.Time
+	// UnschedulablePlugins are the filter plugins which rejected the QueueUnit in
+	// its last schedule attempt.
+	UnschedulablePlugins sets.String
+	// Unresolvable is true if the QueueUnit was rejected as UnschedulableAndUnresolvable
+	// in its last schedule attempt.
+	Unresolvable bool
 }
 
-// NewQueueUnitInfo constructs QueueUnitInfo
+// NewQueueUnitInfo constructs QueueUnitInfo, a requeued QueueUnit keeps the time it
+// was enqueued first
 func NewQueueUnitInfo(unit *v1alpha1.QueueUnit) *QueueUnitInfo {
+	now := time.Now()
+	initial, ok := utils.InitialAttemptTimestamp(unit)
+	if !ok {
+		initial = now
+	}
 	return &QueueUnitInfo{
 		Name:                    unit.Namespace + "/" + unit.Name,
 		Unit:                    unit,
-		Timestamp:               time.Now(),
+		Timestamp:               now,
 		Attempts:                0,
-		InitialAttemptTimestamp: time.Now(),
+		InitialAttemptTimestamp: initial,
 	}
 }
 
 // NewQueueInfo constructs QueueInfo
 func NewQueueInfo(queue *v1alpha1.Queue) *QueueInfo {
 	return &QueueInfo{
-		Name: queue.Namespace,
+		Name:  queue.Namespace + "/" + queue.Name,
 		Queue: queue,
 	}
 }
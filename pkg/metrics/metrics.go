@@ -0,0 +1,63 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package metrics
+
+import (
+	"expvar"
+	"time"
+)
+
+// SchedulingCycleDuration records the duration of the scheduling cycles, it is
+// exposed at /debug/vars with the count of cycles, the total and the last duration
+// in seconds.
+var SchedulingCycleDuration = newDuration("scheduling_cycle_duration_seconds")
+
+// Duration is an expvar recording the durations of an operation.
+type Duration struct {
+	count *expvar.Int
+	sum   *expvar.Float
+	last  *expvar.Float
+}
+
+func newDuration(name string) *Duration {
+	m := expvar.NewMap(name)
+	d := &Duration{
+		count: new(expvar.Int),
+		sum:   new(expvar.Float),
+		last:  new(expvar.Float),
+	}
+	m.Set("count", d.count)
+	m.Set("sum", d.sum)
+	m.Set("last", d.last)
+	return d
+}
+
+// Observe records a duration.
+func (d *Duration) Observe(duration time.Duration) {
+	d.count.Add(1)
+	d.sum.Add(duration.Seconds())
+	d.last.Set(duration.Seconds())
+}
+
+// Since records the duration since the given start time.
+func (d *Duration) Since(start time.Time) {
+	d.Observe(time.Since(start))
+}
+
+// ReconcileRepairs counts the drifts between the informer caches and the in-memory
+// queues and reservations repaired by the reconciler, by kind of repair.
+var ReconcileRepairs = expvar.NewMap("reconcile_repairs_total")
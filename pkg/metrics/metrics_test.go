@@ -0,0 +1,39 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package metrics
+
+import (
+	"expvar"
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	// an unpublished Duration, newDuration publishes its name once per process
+	d := &Duration{count: new(expvar.Int), sum: new(expvar.Float), last: new(expvar.Float)}
+	d.Observe(time.Second)
+	d.Observe(500 * time.Millisecond)
+	if got := d.count.Value(); got != 2 {
+		t.Errorf("count = %v, want 2", got)
+	}
+	if got := d.sum.Value(); got != 1.5 {
+		t.Errorf("sum = %v, want 1.5", got)
+	}
+	if got := d.last.Value(); got != 0.5 {
+		t.Errorf("last = %v, want 0.5", got)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package admissionwindow
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+const (
+	ErrInvalidWindowTemplate   = "invalid admission window %q: %v"
+	ErrInvalidTimeZoneTemplate = "invalid admission time zone %q: %v"
+)
+
+// window is a single admission window which opens at every activation of
+// schedule and stays open for duration.
+type window struct {
+	schedule cron.Schedule
+	duration time.Duration
+}
+
+// Windows is the set of admission windows of a Queue. A Queue admits units
+// only while at least one of its windows is open.
+type Windows struct {
+	windows  []window
+	location *time.Location
+}
+
+// Parse builds the admission windows from the annotations of the given Queue.
+// It returns nil if the Queue has no admission window, meaning that the Queue
+// is always open.
+//
+// Windows are separated by ";", each window is a standard cron expression
+// followed by its duration, e.g. "0 22 * * 1-5 8h;@weekly 48h".
+func Parse(q *v1alpha1.Queue) (*Windows, error) {
+	annotations := q.GetAnnotations()
+	spec := strings.TrimSpace(annotations[utils.AnnotationAdmissionWindows])
+	if spec == "" {
+		return nil, nil
+	}
+
+	location := time.UTC
+	if tz := annotations[utils.AnnotationAdmissionTimeZone]; tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf(ErrInvalidTimeZoneTemplate, tz, err)
+		}
+		location = loc
+	}
+
+	w := &Windows{location: location}
+	for _, s := range strings.Split(spec, ";") {
+		fields := strings.Fields(s)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf(ErrInvalidWindowTemplate, s, "expecting a cron expression followed by a duration")
+		}
+		duration, err := time.ParseDuration(fields[len(fields)-1])
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf(ErrInvalidWindowTemplate, s, "invalid duration")
+		}
+		schedule, err := cron.ParseStandard(strings.Join(fields[:len(fields)-1], " "))
+		if err != nil {
+			return nil, fmt.Errorf(ErrInvalidWindowTemplate, s, err)
+		}
+		w.windows = append(w.windows, window{schedule: schedule, duration: duration})
+	}
+	return w, nil
+}
+
+// Closed returns the admission windows of a Queue which never admits, e.g. because
+// its admission windows are invalid.
+func Closed() *Windows {
+	return &Windows{location: time.UTC}
+}
+
+// Open returns true if any window is open at the given time.
+func (w *Windows) Open(now time.Time) bool {
+	if w == nil {
+		return true
+	}
+	now = now.In(w.location)
+	for _, win := range w.windows {
+		// the window is open if it was activated within the last duration
+		if !win.schedule.Next(now.Add(-win.duration)).After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpening returns the next time after now when a window opens.
+func (w *Windows) NextOpening(now time.Time) time.Time {
+	var next time.Time
+	if w == nil {
+		return next
+	}
+	now = now.In(w.location)
+	for _, win := range w.windows {
+		t := win.schedule.Next(now)
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+	return next
+}
@@ -0,0 +1,122 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package admissionwindow
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+func TestWindows(t *testing.T) {
+	nightly := makeQueue("0 22 * * * 8h", "")
+	tests := []struct {
+		name     string
+		queue    *v1alpha1.Queue
+		now      time.Time
+		wantOpen bool
+		wantNext time.Time
+	}{
+		{
+			name:     "before the nightly window",
+			queue:    nightly,
+			now:      time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC),
+			wantOpen: false,
+			wantNext: time.Date(2021, 10, 1, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "inside the nightly window after midnight",
+			queue:    nightly,
+			now:      time.Date(2021, 10, 2, 3, 0, 0, 0, time.UTC),
+			wantOpen: true,
+			wantNext: time.Date(2021, 10, 2, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "nightly window closed",
+			queue:    nightly,
+			now:      time.Date(2021, 10, 2, 6, 0, 0, 0, time.UTC),
+			wantOpen: false,
+			wantNext: time.Date(2021, 10, 2, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "window in a time zone",
+			queue:    makeQueue("0 22 * * * 8h", "Asia/Shanghai"),
+			now:      time.Date(2021, 10, 1, 15, 0, 0, 0, time.UTC),
+			wantOpen: true,
+			wantNext: time.Date(2021, 10, 2, 14, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "multiple windows",
+			queue:    makeQueue("0 22 * * * 2h;0 12 * * * 1h", ""),
+			now:      time.Date(2021, 10, 1, 12, 30, 0, 0, time.UTC),
+			wantOpen: true,
+			wantNext: time.Date(2021, 10, 1, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "no window",
+			queue:    makeQueue("", ""),
+			now:      time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC),
+			wantOpen: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := Parse(tt.queue)
+			if err != nil {
+				t.Fatalf("Parse() error %v", err)
+			}
+			if got := w.Open(tt.now); got != tt.wantOpen {
+				t.Errorf("Open() = %v, want %v", got, tt.wantOpen)
+			}
+			if got := w.NextOpening(tt.now); !got.Equal(tt.wantNext) {
+				t.Errorf("NextOpening() = %v, want %v", got, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{"0 22 * * *", "0 22 * * * nope", "61 22 * * * 1h"} {
+		if _, err := Parse(makeQueue(spec, "")); err == nil {
+			t.Errorf("expected error for %q", spec)
+		}
+	}
+	if _, err := Parse(makeQueue("@daily 1h", "Nowhere/City")); err == nil {
+		t.Errorf("expected error for invalid time zone")
+	}
+}
+
+func makeQueue(windows, tz string) *v1alpha1.Queue {
+	annotations := map[string]string{}
+	if windows != "" {
+		annotations[utils.AnnotationAdmissionWindows] = windows
+	}
+	if tz != "" {
+		annotations[utils.AnnotationAdmissionTimeZone] = tz
+	}
+	return &v1alpha1.Queue{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "queue",
+			Namespace:   "ns",
+			Annotations: annotations,
+		},
+	}
+}
@@ -218,6 +218,12 @@ func (h *Heap) List() []interface{} {
 	return list
 }
 
+// Reheapify re-establishes the heap ordering. It must be called after the
+// ordering of the items stored in the heap changed.
+func (h *Heap) Reheapify() {
+	heap.Init(h.data)
+}
+
 // Len returns the number of items in the heap.
 func (h *Heap) Len() int {
 	return len(h.data.queue)
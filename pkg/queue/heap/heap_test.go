@@ -269,3 +269,29 @@ func TestHeap_List(t *testing.T) {
 		}
 	}
 }
+
+// TestHeap_Reheapify tests that Reheapify restores the heap invariant after
+// the ordering of the items changed.
+func TestHeap_Reheapify(t *testing.T) {
+	order := map[string]int{"foo": 10, "bar": 1, "baz": 11, "zab": 30}
+	h := New(testHeapObjectKeyFunc, func(val1 interface{}, val2 interface{}) bool {
+		return order[val1.(testHeapObject).name] < order[val2.(testHeapObject).name]
+	})
+	for name := range order {
+		h.Add(mkHeapObj(name, 0))
+	}
+	if item := h.Peek(); item.(testHeapObject).name != "bar" {
+		t.Fatalf("expected bar, got %v", item)
+	}
+
+	order["zab"] = 0
+	h.Reheapify()
+	item, err := h.Pop()
+	if err != nil || item.(testHeapObject).name != "zab" {
+		t.Fatalf("expected zab, got %v", item)
+	}
+	item, err = h.Pop()
+	if err != nil || item.(testHeapObject).name != "bar" {
+		t.Fatalf("expected bar, got %v", item)
+	}
+}
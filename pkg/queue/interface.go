@@ -17,6 +17,9 @@
 package queue
 
 import (
+	"context"
+	"time"
+
 	"github.com/kube-queue/kube-queue/pkg/framework"
 
 	schedv1alpha1 "github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
@@ -27,8 +30,36 @@ type MultiSchedulingQueue interface {
 	Add(*schedv1alpha1.Queue) error
 	Delete(*schedv1alpha1.Queue) error
 	Update(*schedv1alpha1.Queue, *schedv1alpha1.Queue) error
+	// SortedQueue returns the queues in scheduling order, paused queues are skipped.
 	SortedQueue() []SchedulingQueue
+	// GetQueueByName returns the queue with the given namespace/name.
 	GetQueueByName(name string) (SchedulingQueue, bool)
+	// GetQueueByUnit returns the queue a QueueUnit is routed to: the queue named by
+	// spec.queue, or the default queue, in the namespace of the unit. spec.queue can
+	// also be namespace/name of a shared queue. Units without spec.queue fall back
+	// to the only queue of their namespace, like when queues were keyed by namespace.
+	GetQueueByUnit(*schedv1alpha1.QueueUnit) (SchedulingQueue, bool)
+	// AddUnit adds a unit to its queue, or to the fallback queue if its queue does
+	// not exist. Otherwise the unit is parked until its queue is added.
+	AddUnit(*schedv1alpha1.QueueUnit) error
+	// UpdateUnit updates a unit in the queue it was added to, moving it with its
+	// queueing metadata when its queue changes, or parking it when its new queue
+	// does not exist.
+	UpdateUnit(*schedv1alpha1.QueueUnit, *schedv1alpha1.QueueUnit) error
+	// DeleteUnit deletes a unit from the queue it was added to.
+	DeleteUnit(*schedv1alpha1.QueueUnit) error
+	// Orphans returns the parked units whose queue does not exist.
+	Orphans() []*schedv1alpha1.QueueUnit
+	// Units returns the pending units of all the queues and the parked units.
+	Units() []*schedv1alpha1.QueueUnit
+	// Resort re-sorts all the queues after the ordering of their units changed.
+	Resort()
+	// MoveAllToActiveQueue moves the units of all the queues which may become
+	// schedulable on the event out of backoff.
+	MoveAllToActiveQueue(event framework.ClusterEvent)
+	// Wait blocks until a unit of the queues may become schedulable, the timeout
+	// expires or the context is done.
+	Wait(ctx context.Context, timeout time.Duration)
 	Run()
 	Close()
 }
@@ -40,11 +71,34 @@ type SchedulingQueue interface {
 	// the queue, unless it is already in the queue. If there has been a recent move
 	// request, then the queue unit is put in `podBackoffQ`.
 	AddUnschedulableIfNotPresent(*framework.QueueUnitInfo) error
+	// AddQueueUnitInfo adds a unit moved from another queue, keeping its queueing
+	// metadata. The unit is updated if it is in the queue already.
+	AddQueueUnitInfo(*framework.QueueUnitInfo) error
 	Delete(*schedv1alpha1.QueueUnit) error
 	Update(*schedv1alpha1.QueueUnit, *schedv1alpha1.QueueUnit) error
 	Pop() (*framework.QueueUnitInfo, error)
+	// Restore puts a popped queue unit back to the active queue, keeping its
+	// queueing metadata so that it keeps its place.
+	Restore(*framework.QueueUnitInfo) error
+	// Resort re-sorts the active queue after the ordering of its units changed.
+	Resort()
+	// MoveAllToActiveQueue moves the units in backoff which may become schedulable
+	// on the event to the active queue.
+	MoveAllToActiveQueue(event framework.ClusterEvent)
 	Name() string
 	QueueInfo() *framework.QueueInfo
+	// Units returns all the pending units of the queue, including the ones in
+	// backoff, unschedulable or held.
+	Units() []*framework.QueueUnitInfo
+	// UpdateQueue refreshes the Queue object of the scheduling queue in place,
+	// keeping all the units already queued. When the policy changes, the units are
+	// re-sorted with the new policy.
+	UpdateQueue(*schedv1alpha1.Queue) error
+	// Admissible returns false while all the admission windows of the queue are closed.
+	Admissible() bool
+	// NextAdmissionTime returns when the next admission window opens, zero if the
+	// queue has no admission window.
+	NextAdmissionTime() time.Time
 	Length() int
 	Run()
 	GetRunStatus() bool
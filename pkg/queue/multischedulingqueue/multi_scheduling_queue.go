@@ -17,13 +17,19 @@
 package multischedulingqueue
 
 import (
+	"context"
 	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
 
 	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
 	"github.com/kube-queue/kube-queue/pkg/framework"
 	"github.com/kube-queue/kube-queue/pkg/queue"
 	"github.com/kube-queue/kube-queue/pkg/queue/schedulingqueue"
+	"github.com/kube-queue/kube-queue/pkg/utils"
 )
 
 // Making sure that MultiSchedulingQueue implements MultiSchedulingQueue.
@@ -31,21 +37,44 @@ var _ queue.MultiSchedulingQueue = &MultiSchedulingQueue{}
 
 type MultiSchedulingQueue struct {
 	sync.RWMutex
-	fw       framework.Framework
-	queueMap map[string]queue.SchedulingQueue
-	lessFunc framework.MultiQueueLessFunc
+	fw                       framework.Framework
+	queueMap                 map[string]queue.SchedulingQueue
+	lessFunc                 framework.MultiQueueLessFunc
 	podInitialBackoffSeconds int
-	podMaxBackoffSeconds int
+	podMaxBackoffSeconds     int
+	// unschedulableQFlushSeconds and podMaxInUnschedulableQSeconds bound how long
+	// the units stay in the unschedulableQ of the queues
+	unschedulableQFlushSeconds    int
+	podMaxInUnschedulableQSeconds int
+	// defaultQueueName is the name of the queue of the units without spec.queue
+	defaultQueueName string
+	// fallbackQueue is namespace/name of the queue of the units whose queue does
+	// not exist, empty to park them until their queue is added
+	fallbackQueue string
+	// units records the queue of each pending unit, empty for parked units
+	units map[string]string
+	// orphans are the parked units whose queue does not exist, with their queueing
+	// metadata
+	orphans map[string]*framework.QueueUnitInfo
+	// wakeup is signaled when units may become schedulable.
+	wakeup chan struct{}
 }
 
-func NewMultiSchedulingQueue(fw framework.Framework, podInitialBackoffSeconds int, podMaxBackoffSeconds int) (queue.MultiSchedulingQueue, error) {
+func NewMultiSchedulingQueue(fw framework.Framework, podInitialBackoffSeconds int, podMaxBackoffSeconds int, unschedulableQFlushSeconds int, podMaxInUnschedulableQSeconds int, defaultQueueName string, fallbackQueue string) (queue.MultiSchedulingQueue, error) {
 
 	mq := &MultiSchedulingQueue{
-		fw:       fw,
-		queueMap: make(map[string]queue.SchedulingQueue),
-		lessFunc: fw.MultiQueueSortFunc(),
-		podInitialBackoffSeconds: podInitialBackoffSeconds,
-		podMaxBackoffSeconds: podMaxBackoffSeconds,
+		fw:                            fw,
+		queueMap:                      make(map[string]queue.SchedulingQueue),
+		lessFunc:                      fw.MultiQueueSortFunc(),
+		podInitialBackoffSeconds:      podInitialBackoffSeconds,
+		podMaxBackoffSeconds:          podMaxBackoffSeconds,
+		unschedulableQFlushSeconds:    unschedulableQFlushSeconds,
+		podMaxInUnschedulableQSeconds: podMaxInUnschedulableQSeconds,
+		defaultQueueName:              defaultQueueName,
+		fallbackQueue:                 fallbackQueue,
+		units:                         make(map[string]string),
+		orphans:                       make(map[string]*framework.QueueUnitInfo),
+		wakeup:                        make(chan struct{}, 1),
 	}
 
 	return mq, nil
@@ -53,7 +82,7 @@ func NewMultiSchedulingQueue(fw framework.Framework, podInitialBackoffSeconds in
 
 func (mq *MultiSchedulingQueue) Run() {
 	for _, q := range mq.queueMap {
-		if !q.GetRunStatus(){
+		if !q.GetRunStatus() {
 			q.Run()
 			q.SetRunStatus(true)
 		}
@@ -73,34 +102,224 @@ func (mq *MultiSchedulingQueue) Add(q *v1alpha1.Queue) error {
 	mq.Lock()
 	defer mq.Unlock()
 
-	// Name is namespace for the moment
-	name := q.Namespace
-	pq := schedulingqueue.NewPrioritySchedulingQueue(mq.fw, name, string(q.Spec.QueuePolicy), mq.podInitialBackoffSeconds, mq.podMaxBackoffSeconds, q)
+	return mq.addQueue(q)
+}
+
+// addQueue creates the scheduling queue of the Queue, and moves the parked units
+// routed to it into it. A Queue with an unknown policy is rejected, its units stay
+// parked. The caller must hold the lock.
+func (mq *MultiSchedulingQueue) addQueue(q *v1alpha1.Queue) error {
+	name := utils.QueueKey(q.Namespace, q.Name)
+	pq, err := schedulingqueue.NewPrioritySchedulingQueue(mq.fw, name, string(q.Spec.QueuePolicy), mq.podInitialBackoffSeconds, mq.podMaxBackoffSeconds, mq.unschedulableQFlushSeconds, mq.podMaxInUnschedulableQSeconds, q, mq.notify)
+	if err != nil {
+		return err
+	}
 	mq.queueMap[pq.Name()] = pq
 
+	for key, info := range mq.orphans {
+		if target := mq.queueForUnit(info.Unit); target != pq {
+			continue
+		}
+		delete(mq.orphans, key)
+		mq.units[key] = name
+		if err := pq.AddQueueUnitInfo(info); err != nil {
+			klog.Errorf("queue %s add parked unit %s fail %v", name, key, err)
+		}
+	}
+
 	mq.Run()
 	return nil
 }
 
+// Delete removes the queue, its pending units go to the fallback queue, or are
+// parked until their queue is added again. The units keep their queueing metadata.
 func (mq *MultiSchedulingQueue) Delete(q *v1alpha1.Queue) error {
 	mq.Lock()
 	defer mq.Unlock()
 
-	name := q.Namespace
+	name := utils.QueueKey(q.Namespace, q.Name)
+	pq, ok := mq.queueMap[name]
+	if !ok {
+		return nil
+	}
 	delete(mq.queueMap, name)
+	pq.Close()
+
+	for _, info := range pq.Units() {
+		if err := pq.Delete(info.Unit); err != nil {
+			klog.Errorf("queue %s delete unit %s fail %v", name, info.Name, err)
+		}
+		if err := mq.moveUnit(info); err != nil {
+			klog.Errorf("move unit %s of deleted queue %s fail %v", info.Name, name, err)
+		}
+	}
 	return nil
 }
 
-func (mq *MultiSchedulingQueue) Update(old *v1alpha1.Queue, new *v1alpha1.Queue) error {
+func (mq *MultiSchedulingQueue) AddUnit(unit *v1alpha1.QueueUnit) error {
 	mq.Lock()
 	defer mq.Unlock()
 
-	name := new.Namespace
-	pq := schedulingqueue.NewPrioritySchedulingQueue(mq.fw, name, string(new.Spec.QueuePolicy), mq.podInitialBackoffSeconds, mq.podMaxBackoffSeconds, new)
-	mq.queueMap[pq.Name()] = pq
+	return mq.addUnit(unit)
+}
+
+// addUnit adds the unit to its queue, or parks it if the queue does not exist. The
+// caller must hold the lock.
+func (mq *MultiSchedulingQueue) addUnit(unit *v1alpha1.QueueUnit) error {
+	key := unitKey(unit)
+	q := mq.queueForUnit(unit)
+	if q == nil {
+		klog.Infof("queue of unit %s is not exist, park it", key)
+		mq.orphans[key] = framework.NewQueueUnitInfo(unit)
+		mq.units[key] = ""
+		return nil
+	}
+	mq.units[key] = q.Name()
+	return q.Add(unit)
+}
+
+// moveUnit adds the unit which left its queue to its new queue, keeping its queueing
+// metadata, or parks it if the queue does not exist. The caller must hold the lock.
+func (mq *MultiSchedulingQueue) moveUnit(info *framework.QueueUnitInfo) error {
+	q := mq.queueForUnit(info.Unit)
+	if q == nil {
+		klog.Infof("queue of unit %s is not exist, park it", info.Name)
+		mq.orphans[info.Name] = info
+		mq.units[info.Name] = ""
+		return nil
+	}
+	mq.units[info.Name] = q.Name()
+	return q.AddQueueUnitInfo(info)
+}
+
+func (mq *MultiSchedulingQueue) UpdateUnit(old *v1alpha1.QueueUnit, new *v1alpha1.QueueUnit) error {
+	mq.Lock()
+	defer mq.Unlock()
+
+	key := unitKey(new)
+	current, ok := mq.units[key]
+	if !ok {
+		return mq.addUnit(new)
+	}
+	if current == "" {
+		info := mq.orphans[key]
+		delete(mq.orphans, key)
+		info.Unit = new
+		return mq.moveUnit(info)
+	}
+	q, ok := mq.queueMap[current]
+	if !ok {
+		return mq.addUnit(new)
+	}
+
+	// the unit moves to another queue when its spec.queue changes, or is parked when
+	// its new queue does not exist
+	if target := mq.queueForUnit(new); target != q {
+		info := queuedUnit(q, key)
+		if err := q.Delete(old); err != nil {
+			klog.Errorf("queue %s delete unit %s fail %v", current, key, err)
+		}
+		// the unit popped by the scheduler has no metadata left in its queue
+		if info == nil {
+			return mq.addUnit(new)
+		}
+		info.Unit = new
+		return mq.moveUnit(info)
+	}
+	return q.Update(old, new)
+}
+
+// queuedUnit returns the pending unit of the queue with the given key, nil if it is
+// not in the queue.
+func queuedUnit(q queue.SchedulingQueue, key string) *framework.QueueUnitInfo {
+	for _, info := range q.Units() {
+		if info.Name == key {
+			return info
+		}
+	}
 	return nil
 }
 
+func (mq *MultiSchedulingQueue) DeleteUnit(unit *v1alpha1.QueueUnit) error {
+	mq.Lock()
+	defer mq.Unlock()
+
+	key := unitKey(unit)
+	current, ok := mq.units[key]
+	if !ok {
+		return nil
+	}
+	delete(mq.units, key)
+	delete(mq.orphans, key)
+	if q, ok := mq.queueMap[current]; ok {
+		return q.Delete(unit)
+	}
+	return nil
+}
+
+func (mq *MultiSchedulingQueue) Orphans() []*v1alpha1.QueueUnit {
+	mq.RLock()
+	defer mq.RUnlock()
+
+	orphans := make([]*v1alpha1.QueueUnit, 0, len(mq.orphans))
+	for _, info := range mq.orphans {
+		orphans = append(orphans, info.Unit)
+	}
+	return orphans
+}
+
+func (mq *MultiSchedulingQueue) Units() []*v1alpha1.QueueUnit {
+	mq.RLock()
+	defer mq.RUnlock()
+
+	units := make([]*v1alpha1.QueueUnit, 0, len(mq.units))
+	for _, q := range mq.queueMap {
+		for _, info := range q.Units() {
+			units = append(units, info.Unit)
+		}
+	}
+	for _, info := range mq.orphans {
+		units = append(units, info.Unit)
+	}
+	return units
+}
+
+func unitKey(unit *v1alpha1.QueueUnit) string {
+	return unit.Namespace + "/" + unit.Name
+}
+
+func (mq *MultiSchedulingQueue) Update(old *v1alpha1.Queue, new *v1alpha1.Queue) error {
+	mq.Lock()
+	defer mq.Unlock()
+
+	// queues are updated in place to keep their units, the ordering of the queues
+	// is computed when scheduling so priority changes need nothing else
+	name := utils.QueueKey(new.Namespace, new.Name)
+	if q, ok := mq.queueMap[name]; ok {
+		return q.UpdateQueue(new)
+	}
+	return mq.addQueue(new)
+}
+
+// notify wakes up Wait without blocking, signals are coalesced until Wait returns.
+func (mq *MultiSchedulingQueue) notify() {
+	select {
+	case mq.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (mq *MultiSchedulingQueue) Wait(ctx context.Context, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-mq.wakeup:
+	case <-timer.C:
+	}
+}
+
 func (mq *MultiSchedulingQueue) GetQueueByName(name string) (queue.SchedulingQueue, bool) {
 	mq.RLock()
 	defer mq.RUnlock()
@@ -109,17 +328,94 @@ func (mq *MultiSchedulingQueue) GetQueueByName(name string) (queue.SchedulingQue
 	return q, ok
 }
 
-func (mq *MultiSchedulingQueue) SortedQueue() []queue.SchedulingQueue {
+func (mq *MultiSchedulingQueue) GetQueueByUnit(unit *v1alpha1.QueueUnit) (queue.SchedulingQueue, bool) {
 	mq.RLock()
 	defer mq.RUnlock()
 
-	len := len(mq.queueMap)
-	unSortedQueue := make([]queue.SchedulingQueue, len)
+	return mq.routeUnit(unit)
+}
+
+// queueForUnit returns the queue of the unit, or the fallback queue if it does not
+// exist. The caller must hold the lock.
+func (mq *MultiSchedulingQueue) queueForUnit(unit *v1alpha1.QueueUnit) queue.SchedulingQueue {
+	if q, ok := mq.routeUnit(unit); ok {
+		return q
+	}
+	if q, ok := mq.queueMap[mq.fallbackQueue]; ok {
+		return q
+	}
+	return nil
+}
+
+// routeUnit implements GetQueueByUnit, the caller must hold the lock.
+func (mq *MultiSchedulingQueue) routeUnit(unit *v1alpha1.QueueUnit) (queue.SchedulingQueue, bool) {
+	namespace := utils.QueueUnitNamespace(unit)
+	name := unit.Spec.Queue
+	if strings.Contains(name, "/") {
+		q, ok := mq.queueMap[name]
+		if !ok {
+			return nil, false
+		}
+		queueObj := q.QueueInfo().Queue
+		if queueObj.Namespace != namespace && !utils.IsSharedQueue(queueObj) {
+			klog.Errorf("queue %s is not shared with namespace %s", name, namespace)
+			return nil, false
+		}
+		return q, true
+	}
 
-	index := 0
+	if name == "" {
+		name = mq.defaultQueueName
+	}
+	if q, ok := mq.queueMap[utils.QueueKey(namespace, name)]; ok {
+		return q, true
+	}
+	if unit.Spec.Queue != "" {
+		return nil, false
+	}
+	// queues used to be keyed by namespace, units without spec.queue keep going
+	// to the only queue of their namespace
+	var found queue.SchedulingQueue
+	for _, q := range mq.queueMap {
+		if q.QueueInfo().Queue.Namespace != namespace {
+			continue
+		}
+		if found != nil {
+			return nil, false
+		}
+		found = q
+	}
+	return found, found != nil
+}
+
+func (mq *MultiSchedulingQueue) Resort() {
+	mq.RLock()
+	defer mq.RUnlock()
+
+	for _, q := range mq.queueMap {
+		q.Resort()
+	}
+}
+
+func (mq *MultiSchedulingQueue) MoveAllToActiveQueue(event framework.ClusterEvent) {
+	mq.RLock()
+	defer mq.RUnlock()
+
+	for _, q := range mq.queueMap {
+		q.MoveAllToActiveQueue(event)
+	}
+}
+
+func (mq *MultiSchedulingQueue) SortedQueue() []queue.SchedulingQueue {
+	mq.RLock()
+	defer mq.RUnlock()
+
+	unSortedQueue := make([]queue.SchedulingQueue, 0, len(mq.queueMap))
 	for _, q := range mq.queueMap {
-		unSortedQueue[index] = q
-		index++
+		if utils.IsHeld(q.QueueInfo().Queue) {
+			continue
+		}
+		unSortedQueue = append(unSortedQueue, q)
 	}
 
 	sort.Slice(unSortedQueue, func(i, j int) bool {
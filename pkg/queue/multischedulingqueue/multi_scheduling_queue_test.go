@@ -0,0 +1,341 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package multischedulingqueue
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/priority"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/shortestjobfirst"
+	"github.com/kube-queue/kube-queue/pkg/framework/runtime"
+	"github.com/kube-queue/kube-queue/pkg/queue"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+func newTestMultiQueue(t *testing.T, fallbackQueue string) *MultiSchedulingQueue {
+	fw, err := runtime.NewFramework(runtime.Registry{
+		priority.Name:         priority.New,
+		shortestjobfirst.Name: shortestjobfirst.New,
+	}, nil, "", informers.NewSharedInformerFactory(nil, 0), nil, nil)
+	if err != nil {
+		t.Fatalf("new framework failed %v", err)
+	}
+	mq, err := NewMultiSchedulingQueue(fw, 1, 20, 30, 300, "default", fallbackQueue)
+	if err != nil {
+		t.Fatalf("new multi scheduling queue failed %v", err)
+	}
+	return mq.(*MultiSchedulingQueue)
+}
+
+func TestGetQueueByUnit(t *testing.T) {
+	mq := newTestMultiQueue(t, "")
+	for _, q := range []*v1alpha1.Queue{
+		makeQueue("ns1", "default", nil),
+		makeQueue("ns1", "gpu", nil),
+		makeQueue("ns2", "legacy", nil),
+		makeQueue("ns3", "shared", map[string]string{utils.AnnotationSharedQueue: "true"}),
+		makeQueue("ns4", "private", nil),
+	} {
+		if err := mq.Add(q); err != nil {
+			t.Fatalf("add queue failed %v", err)
+		}
+	}
+	defer mq.Close()
+
+	tests := []struct {
+		name      string
+		namespace string
+		queue     string
+		want      string
+	}{
+		{"spec.queue in the namespace", "ns1", "gpu", "ns1/gpu"},
+		{"default queue", "ns1", "", "ns1/default"},
+		{"only queue of the namespace", "ns2", "", "ns2/legacy"},
+		{"missing queue", "ns2", "gpu", ""},
+		{"shared queue", "ns1", "ns3/shared", "ns3/shared"},
+		{"queue not shared", "ns1", "ns4/private", ""},
+		{"namespace without queue", "ns5", "", ""},
+	}
+	for _, tt := range tests {
+		unit := &v1alpha1.QueueUnit{
+			ObjectMeta: metav1.ObjectMeta{Name: "unit", Namespace: tt.namespace},
+			Spec: v1alpha1.QueueUnitSpec{
+				ConsumerRef: &corev1.ObjectReference{Namespace: tt.namespace},
+				Queue:       tt.queue,
+			},
+		}
+		got := ""
+		if q, ok := mq.GetQueueByUnit(unit); ok {
+			got = q.Name()
+		}
+		if got != tt.want {
+			t.Errorf("%s: GetQueueByUnit() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	mq := newTestMultiQueue(t, "")
+	defer mq.Close()
+	old := makeQueue("ns", "queue", nil)
+	if err := mq.Add(old); err != nil {
+		t.Fatalf("add queue failed %v", err)
+	}
+	q, _ := mq.GetQueueByName("ns/queue")
+	for _, name := range []string{"qu1", "qu2"} {
+		if err := q.Add(makeQueueUnit(name)); err != nil {
+			t.Fatalf("add unit %s failed %v", name, err)
+		}
+	}
+
+	priority := int32(100)
+	tests := []struct {
+		name   string
+		update func(*v1alpha1.Queue)
+	}{
+		{"priority change", func(q *v1alpha1.Queue) { q.Spec.Priority = &priority }},
+		{"policy change", func(q *v1alpha1.Queue) { q.Spec.QueuePolicy = shortestjobfirst.Name }},
+		{"annotation change", func(q *v1alpha1.Queue) { q.Annotations = map[string]string{"foo": "bar"} }},
+	}
+	for _, tt := range tests {
+		new := old.DeepCopy()
+		tt.update(new)
+		if err := mq.Update(old, new); err != nil {
+			t.Fatalf("%s: update failed %v", tt.name, err)
+		}
+		got, ok := mq.GetQueueByName("ns/queue")
+		if !ok || got != q {
+			t.Fatalf("%s: expected the queue to be updated in place", tt.name)
+		}
+		if got.Length() != 2 {
+			t.Errorf("%s: expected 2 pending units, got %d", tt.name, got.Length())
+		}
+		if got.QueueInfo().Queue != new {
+			t.Errorf("%s: expected the queue object to be refreshed", tt.name)
+		}
+		old = new
+	}
+
+	missing := makeQueue("ns", "missing", nil)
+	if err := mq.Update(missing, missing); err != nil {
+		t.Fatalf("update missing queue failed %v", err)
+	}
+	if q, ok := mq.GetQueueByName("ns/missing"); !ok || !q.GetRunStatus() {
+		t.Errorf("expected missing queue to be added and running")
+	}
+}
+
+func TestOrphanUnits(t *testing.T) {
+	mq := newTestMultiQueue(t, "")
+	defer mq.Close()
+	unit := makeQueueUnit("qu1")
+	unit.Spec.Queue = "gpu"
+	if err := mq.AddUnit(unit); err != nil {
+		t.Fatalf("add unit failed %v", err)
+	}
+	if len(mq.Orphans()) != 1 {
+		t.Fatalf("expected the unit to be parked, got %d orphans", len(mq.Orphans()))
+	}
+	if len(mq.Units()) != 1 {
+		t.Fatalf("expected the parked unit to be listed, got %d units", len(mq.Units()))
+	}
+
+	if err := mq.Add(makeQueue("ns", "gpu", nil)); err != nil {
+		t.Fatalf("add queue failed %v", err)
+	}
+	if len(mq.Orphans()) != 0 {
+		t.Fatalf("expected the parked unit to be drained, got %d orphans", len(mq.Orphans()))
+	}
+	q, _ := mq.GetQueueByName("ns/gpu")
+	if q.Length() != 1 {
+		t.Errorf("expected 1 pending unit in ns/gpu, got %d", q.Length())
+	}
+
+	if err := mq.Delete(makeQueue("ns", "gpu", nil)); err != nil {
+		t.Fatalf("delete queue failed %v", err)
+	}
+	if len(mq.Orphans()) != 1 {
+		t.Fatalf("expected the unit to be parked again, got %d orphans", len(mq.Orphans()))
+	}
+	if err := mq.DeleteUnit(unit); err != nil {
+		t.Fatalf("delete unit failed %v", err)
+	}
+	if len(mq.Orphans()) != 0 {
+		t.Errorf("expected the deleted unit to be unparked, got %d orphans", len(mq.Orphans()))
+	}
+}
+
+func TestDeleteMovesUnitsToFallbackQueue(t *testing.T) {
+	mq := newTestMultiQueue(t, "ns/fallback")
+	defer mq.Close()
+	for _, name := range []string{"gpu", "fallback"} {
+		if err := mq.Add(makeQueue("ns", name, nil)); err != nil {
+			t.Fatalf("add queue failed %v", err)
+		}
+	}
+	unit := makeQueueUnit("qu1")
+	unit.Spec.Queue = "gpu"
+	if err := mq.AddUnit(unit); err != nil {
+		t.Fatalf("add unit failed %v", err)
+	}
+
+	if err := mq.Delete(makeQueue("ns", "gpu", nil)); err != nil {
+		t.Fatalf("delete queue failed %v", err)
+	}
+	fallback, _ := mq.GetQueueByName("ns/fallback")
+	if fallback.Length() != 1 || len(mq.Orphans()) != 0 {
+		t.Fatalf("expected the unit to move to the fallback queue, got %d pending and %d orphans", fallback.Length(), len(mq.Orphans()))
+	}
+
+	updated := unit.DeepCopy()
+	updated.Spec.Priority = new(int32)
+	if err := mq.UpdateUnit(unit, updated); err != nil {
+		t.Fatalf("update unit failed %v", err)
+	}
+	if err := mq.DeleteUnit(updated); err != nil {
+		t.Fatalf("delete unit failed %v", err)
+	}
+	if fallback.Length() != 0 {
+		t.Errorf("expected the unit to be deleted from the fallback queue, got %d pending", fallback.Length())
+	}
+}
+
+func TestDeleteKeepsQueueingMetadata(t *testing.T) {
+	for _, fallbackQueue := range []string{"ns/fallback", ""} {
+		mq := newTestMultiQueue(t, fallbackQueue)
+		for _, name := range []string{"gpu", "fallback"} {
+			if err := mq.Add(makeQueue("ns", name, nil)); err != nil {
+				t.Fatalf("add queue failed %v", err)
+			}
+		}
+		unit := makeQueueUnit("qu1")
+		unit.Spec.Queue = "gpu"
+		if err := mq.AddUnit(unit); err != nil {
+			t.Fatalf("add unit failed %v", err)
+		}
+		gpu, _ := mq.GetQueueByName("ns/gpu")
+		info := gpu.Units()[0]
+		info.Attempts = 3
+		info.InitialAttemptTimestamp = time.Now().Add(-time.Hour)
+		info.BlockedSince = time.Now().Add(-time.Minute)
+		want := *info
+
+		if err := mq.Delete(makeQueue("ns", "gpu", nil)); err != nil {
+			t.Fatalf("delete queue failed %v", err)
+		}
+		target := fallbackQueue
+		if fallbackQueue == "" {
+			// the parked unit keeps its metadata until its queue is added again
+			if len(mq.Orphans()) != 1 {
+				t.Fatalf("expected the unit to be parked, got %d orphans", len(mq.Orphans()))
+			}
+			if err := mq.Add(makeQueue("ns", "gpu", nil)); err != nil {
+				t.Fatalf("add queue failed %v", err)
+			}
+			target = "ns/gpu"
+		}
+		q, _ := mq.GetQueueByName(target)
+		if len(q.Units()) != 1 || len(mq.Units()) != 1 {
+			t.Fatalf("fallback %q: expected the unit to be queued in %s", fallbackQueue, target)
+		}
+		got := q.Units()[0]
+		if got.Attempts != want.Attempts || !got.InitialAttemptTimestamp.Equal(want.InitialAttemptTimestamp) || !got.BlockedSince.Equal(want.BlockedSince) {
+			t.Errorf("fallback %q: expected the moved unit to keep its metadata %+v, got %+v", fallbackQueue, want, *got)
+		}
+		if got.QueueName != q.Name() {
+			t.Errorf("fallback %q: expected the moved unit to belong to %s, got %s", fallbackQueue, q.Name(), got.QueueName)
+		}
+		mq.Close()
+	}
+}
+
+func TestUpdateUnitMovesQueueingMetadata(t *testing.T) {
+	mq := newTestMultiQueue(t, "")
+	defer mq.Close()
+	for _, name := range []string{"gpu", "cpu"} {
+		if err := mq.Add(makeQueue("ns", name, nil)); err != nil {
+			t.Fatalf("add queue failed %v", err)
+		}
+	}
+	unit := makeQueueUnit("qu1")
+	unit.Spec.Queue = "gpu"
+	if err := mq.AddUnit(unit); err != nil {
+		t.Fatalf("add unit failed %v", err)
+	}
+	gpu, _ := mq.GetQueueByName("ns/gpu")
+	cpu, _ := mq.GetQueueByName("ns/cpu")
+	info := gpu.Units()[0]
+	info.Attempts = 3
+	info.InitialAttemptTimestamp = time.Now().Add(-time.Hour)
+
+	steps := []struct {
+		queue   string
+		pending queue.SchedulingQueue
+	}{
+		// the unit moves to the new queue
+		{"cpu", cpu},
+		// the unit is parked when its new queue does not exist
+		{"missing", nil},
+		// the parked unit moves to its queue
+		{"gpu", gpu},
+	}
+	old := unit
+	for _, step := range steps {
+		new := old.DeepCopy()
+		new.Spec.Queue = step.queue
+		if err := mq.UpdateUnit(old, new); err != nil {
+			t.Fatalf("%s: update unit failed %v", step.queue, err)
+		}
+		for _, q := range []queue.SchedulingQueue{gpu, cpu} {
+			if want, got := q == step.pending, len(q.Units()) == 1; want != got {
+				t.Errorf("%s: expected the unit in %s to be %v, got %v", step.queue, q.Name(), want, got)
+			}
+		}
+		if want, got := step.pending == nil, len(mq.Orphans()) == 1; want != got {
+			t.Errorf("%s: expected the unit to be parked %v, got %v", step.queue, want, got)
+		}
+		old = new
+	}
+
+	got := gpu.Units()[0]
+	if got.Attempts != info.Attempts || !got.InitialAttemptTimestamp.Equal(info.InitialAttemptTimestamp) || got.Unit != old {
+		t.Errorf("expected the moved unit to keep its metadata %+v, got %+v", *info, *got)
+	}
+}
+
+func makeQueueUnit(name string) *v1alpha1.QueueUnit {
+	return &v1alpha1.QueueUnit{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"},
+	}
+}
+
+func makeQueue(namespace, name string, annotations map[string]string) *v1alpha1.Queue {
+	return &v1alpha1.Queue{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+		Spec: v1alpha1.QueueSpec{QueuePolicy: v1alpha1.QueuePolicyPriority},
+	}
+}
@@ -18,6 +18,7 @@ package schedulingqueue
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -28,9 +29,18 @@ import (
 	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
 	"github.com/kube-queue/kube-queue/pkg/framework"
 	"github.com/kube-queue/kube-queue/pkg/queue"
+	"github.com/kube-queue/kube-queue/pkg/queue/admissionwindow"
 	"github.com/kube-queue/kube-queue/pkg/queue/heap"
+	"github.com/kube-queue/kube-queue/pkg/utils"
 )
 
+// defaultQueueSortPlugin is used when the queue has no policy.
+const defaultQueueSortPlugin = "Priority"
+
+// ErrUnknownQueuePolicyTemplate is the error of a queue whose policy is neither FIFO
+// nor a queue sort plugin.
+const ErrUnknownQueuePolicyTemplate = "unknown policy %q of queue %s"
+
 // Making sure that PrioritySchedulingQueue implements SchedulingQueue.
 var _ queue.SchedulingQueue = &PrioritySchedulingQueue{}
 
@@ -41,44 +51,121 @@ type PrioritySchedulingQueue struct {
 	fw         framework.Framework
 	items      *heap.Heap
 	backoffQ   *heap.Heap
-	queue      *framework.QueueInfo
-	clock      util.Clock
+	// held keeps the units that are held by users, they are never popped
+	// until released.
+	held map[string]*framework.QueueUnitInfo
+	// unschedulableQ keeps the units rejected as UnschedulableAndUnresolvable, they
+	// are only retried on relevant events or after podMaxInUnschedulableQDuration.
+	unschedulableQ map[string]*framework.QueueUnitInfo
+	queue          *framework.QueueInfo
+	// windows are the admission windows of the queue, nil means always open.
+	windows *admissionwindow.Windows
+	clock   util.Clock
 	// pod initial backoff duration.
 	podInitialBackoffDuration time.Duration
 	// pod maximum backoff duration.
 	podMaxBackoffDuration time.Duration
-	stop                  chan struct{}
-	closed                bool
-	run                   bool
+	// unschedulableQFlushInterval is how often the units staying in unschedulableQ
+	// for too long are retried.
+	unschedulableQFlushInterval time.Duration
+	// podMaxInUnschedulableQDuration is how long a unit stays in unschedulableQ at
+	// most before it is retried, if no relevant event moves it earlier.
+	podMaxInUnschedulableQDuration time.Duration
+	// refreshInterval is how often the active queue is re-sorted, 0 means never.
+	refreshInterval time.Duration
+	// refreshStop stops the refresher of the active queue, nil if not running.
+	refreshStop chan struct{}
+	// notify wakes up the scheduling loop when units may become schedulable.
+	notify func()
+	stop   chan struct{}
+	closed bool
+	run    bool
 }
 
-func NewPrioritySchedulingQueue(fw framework.Framework, name string, pluginName string, podInitialBackoffSeconds int, podMaxBackoffSeconds int, queue *v1alpha1.Queue) queue.SchedulingQueue {
-	queueSortFuncMap := fw.QueueSortFuncMap()
-	lessFn := queueSortFuncMap[pluginName]
-
-	comp := func(queueUnitInfo1, queueUnitInfo2 interface{}) bool {
-		quInfo1 := queueUnitInfo1.(*framework.QueueUnitInfo)
-		quInfo2 := queueUnitInfo2.(*framework.QueueUnitInfo)
-		return lessFn(quInfo1, quInfo2)
+// NewPrioritySchedulingQueue creates a scheduling queue, notify is called when units
+// may become schedulable and can be nil. It fails if the policy is unknown.
+func NewPrioritySchedulingQueue(fw framework.Framework, name string, pluginName string, podInitialBackoffSeconds int, podMaxBackoffSeconds int, unschedulableQFlushSeconds int, podMaxInUnschedulableQSeconds int, queue *v1alpha1.Queue, notify func()) (queue.SchedulingQueue, error) {
+	lessFn, err := compareFunc(fw, name, pluginName)
+	if err != nil {
+		return nil, err
 	}
-
 	q := &PrioritySchedulingQueue{
-		fw:                        fw,
-		name:                      name,
-		pluginName:                pluginName,
-		items:                     heap.New(unitInfoKeyFunc, comp),
-		podInitialBackoffDuration: time.Duration(podInitialBackoffSeconds) * time.Second,
-		podMaxBackoffDuration:     time.Duration(podMaxBackoffSeconds) * time.Second,
-		clock:                     util.RealClock{},
-		queue:                     framework.NewQueueInfo(queue),
+		fw:                             fw,
+		name:                           name,
+		pluginName:                     pluginName,
+		items:                          heap.New(unitInfoKeyFunc, lessFn),
+		held:                           make(map[string]*framework.QueueUnitInfo),
+		unschedulableQ:                 make(map[string]*framework.QueueUnitInfo),
+		podInitialBackoffDuration:      time.Duration(podInitialBackoffSeconds) * time.Second,
+		podMaxBackoffDuration:          time.Duration(podMaxBackoffSeconds) * time.Second,
+		unschedulableQFlushInterval:    time.Duration(unschedulableQFlushSeconds) * time.Second,
+		podMaxInUnschedulableQDuration: time.Duration(podMaxInUnschedulableQSeconds) * time.Second,
+		refreshInterval:                fw.QueueSortRefreshInterval(pluginName),
+		notify:                         notify,
+		stop:                           make(chan struct{}),
+		clock:                          util.RealClock{},
+		queue:                          framework.NewQueueInfo(queue),
 	}
 
 	q.backoffQ = heap.NewWithRecorder(unitInfoKeyFunc, q.podsCompareBackoffCompleted)
-	return q
+	q.windows = parseAdmissionWindows(queue)
+	if q.notify == nil {
+		q.notify = func() {}
+	}
+	return q, nil
+}
+
+// compareFunc returns the function comparing the units of a queue sorted by the
+// given policy: FIFO, or the name of a queue sort plugin. The queues without policy
+// are sorted by priority.
+func compareFunc(fw framework.Framework, name string, pluginName string) (func(interface{}, interface{}) bool, error) {
+	var lessFn framework.QueueLessFunc
+	switch pluginName {
+	case string(v1alpha1.QueuePolicyFIFO):
+		lessFn = fifoLess
+	case "":
+		lessFn = fw.QueueSortFuncMap()[defaultQueueSortPlugin]
+	default:
+		fn, ok := fw.QueueSortFuncMap()[pluginName]
+		if !ok {
+			return nil, fmt.Errorf(ErrUnknownQueuePolicyTemplate, pluginName, name)
+		}
+		lessFn = fn
+	}
+
+	return func(queueUnitInfo1, queueUnitInfo2 interface{}) bool {
+		quInfo1 := queueUnitInfo1.(*framework.QueueUnitInfo)
+		quInfo2 := queueUnitInfo2.(*framework.QueueUnitInfo)
+		return lessFn(quInfo1, quInfo2)
+	}, nil
+}
+
+// fifoLess orders the units of a FIFO queue by the time they were first queued
+func fifoLess(quInfo1 *framework.QueueUnitInfo, quInfo2 *framework.QueueUnitInfo) bool {
+	return quInfo1.InitialAttemptTimestamp.Before(quInfo2.InitialAttemptTimestamp)
 }
 
 func (p *PrioritySchedulingQueue) Run() {
 	go wait.Until(p.flushBackoffQCompleted, 1.0*time.Second, p.stop)
+	go wait.Until(p.flushUnschedulableQLeftover, p.unschedulableQFlushInterval, p.stop)
+	p.Lock()
+	defer p.Unlock()
+	p.startRefresh()
+}
+
+// startRefresh starts re-sorting the active queue every refreshInterval, stopping
+// the previous refresher if any. The caller must hold the lock.
+func (p *PrioritySchedulingQueue) startRefresh() {
+	if p.refreshStop != nil {
+		close(p.refreshStop)
+		p.refreshStop = nil
+	}
+	if p.refreshInterval <= 0 || p.closed {
+		return
+	}
+	stop := make(chan struct{})
+	p.refreshStop = stop
+	go wait.Until(p.Resort, p.refreshInterval, stop)
 }
 
 func (p *PrioritySchedulingQueue) GetRunStatus() bool {
@@ -93,6 +180,10 @@ func (p *PrioritySchedulingQueue) Close() {
 	p.Lock()
 	defer p.Unlock()
 	close(p.stop)
+	if p.refreshStop != nil {
+		close(p.refreshStop)
+		p.refreshStop = nil
+	}
 	p.closed = true
 }
 
@@ -100,12 +191,39 @@ func (p *PrioritySchedulingQueue) Add(q *v1alpha1.QueueUnit) error {
 	p.Lock()
 	defer p.Unlock()
 
-	info := framework.NewQueueUnitInfo(q)
+	// adding a unit twice keeps its queueing metadata
+	if p.contains(fmt.Sprintf("%v/%v", q.Namespace, q.Name)) {
+		return p.update(q)
+	}
+	return p.add(framework.NewQueueUnitInfo(q))
+}
+
+func (p *PrioritySchedulingQueue) AddQueueUnitInfo(info *framework.QueueUnitInfo) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.contains(info.Name) {
+		return p.update(info.Unit)
+	}
+	return p.add(info)
+}
+
+// add queues a unit which is not in the queue, the held units are kept aside and the
+// others go to the active queue. The caller must hold the lock.
+func (p *PrioritySchedulingQueue) add(info *framework.QueueUnitInfo) error {
+	info.QueueName = p.name
+	p.fw.RunQueueUnitAdded(p.pluginName, info)
+	if utils.IsHeld(info.Unit) {
+		p.held[info.Name] = info
+		return nil
+	}
 	err := p.items.Add(info)
 	if err != nil {
 		klog.Infof("err %v", err)
+		return err
 	}
-	return err
+	p.notify()
+	return nil
 }
 
 func (p *PrioritySchedulingQueue) AddUnschedulableIfNotPresent(quInfo *framework.QueueUnitInfo) error {
@@ -120,6 +238,20 @@ func (p *PrioritySchedulingQueue) AddUnschedulableIfNotPresent(quInfo *framework
 	if ok {
 		return nil
 	}
+	if _, ok = p.held[quInfo.Name]; ok {
+		return nil
+	}
+	if _, ok = p.unschedulableQ[quInfo.Name]; ok {
+		return nil
+	}
+	if utils.IsHeld(quInfo.Unit) {
+		p.held[quInfo.Name] = quInfo
+		return nil
+	}
+	if quInfo.Unresolvable {
+		p.unschedulableQ[quInfo.Name] = quInfo
+		return nil
+	}
 
 	return p.backoffQ.Add(quInfo)
 }
@@ -129,6 +261,11 @@ func (p *PrioritySchedulingQueue) Delete(q *v1alpha1.QueueUnit) error {
 	defer p.Unlock()
 
 	key := fmt.Sprintf("%v/%v", q.Namespace, q.Name)
+	// the unit may be popped already, e.g. when it is deleted for being admitted
+	deleted := framework.NewQueueUnitInfo(q)
+	deleted.QueueName = p.name
+	defer p.fw.RunQueueUnitDeleted(p.pluginName, deleted)
+
 	info, ok, _ := p.items.GetByKey(key)
 	if ok {
 		err := p.items.Delete(info)
@@ -141,6 +278,8 @@ func (p *PrioritySchedulingQueue) Delete(q *v1alpha1.QueueUnit) error {
 		return err
 	}
 
+	delete(p.held, key)
+	delete(p.unschedulableQ, key)
 	return nil
 }
 
@@ -148,19 +287,99 @@ func (p *PrioritySchedulingQueue) Update(old *v1alpha1.QueueUnit, new *v1alpha1.
 	p.Lock()
 	defer p.Unlock()
 
+	return p.update(new)
+}
+
+// contains returns true if the unit is pending in the queue. The caller must hold
+// the lock.
+func (p *PrioritySchedulingQueue) contains(key string) bool {
+	if _, ok := p.held[key]; ok {
+		return true
+	}
+	if _, ok := p.unschedulableQ[key]; ok {
+		return true
+	}
+	for _, h := range []*heap.Heap{p.items, p.backoffQ} {
+		if _, ok, _ := h.GetByKey(key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// update refreshes the unit where it is pending, keeping its queueing metadata. The
+// caller must hold the lock.
+func (p *PrioritySchedulingQueue) update(new *v1alpha1.QueueUnit) error {
 	newInfo := framework.NewQueueUnitInfo(new)
+	newInfo.QueueName = p.name
 	key := fmt.Sprintf("%v/%v", new.Namespace, new.Name)
-	_, ok, _ := p.items.GetByKey(key)
-	if ok {
-		err := p.items.Update(newInfo)
-		return err
+	if utils.IsHeld(new) {
+		return p.hold(key, newInfo)
+	}
+	if info, ok := p.held[key]; ok {
+		return p.release(info, new)
+	}
+	// the update may make an unschedulable unit schedulable, retry it
+	if info, ok := p.unschedulableQ[key]; ok {
+		delete(p.unschedulableQ, key)
+		info.Unit = new
+		if err := p.items.Add(info); err != nil {
+			return err
+		}
+		p.notify()
+		return nil
 	}
 
-	_, ok, _ = p.backoffQ.GetByKey(key)
-	if ok {
-		err := p.backoffQ.Update(newInfo)
+	for _, h := range []*heap.Heap{p.items, p.backoffQ} {
+		obj, ok, _ := h.GetByKey(key)
+		if !ok {
+			continue
+		}
+		info := obj.(*framework.QueueUnitInfo)
+		info.Unit = new
+		return h.Update(info)
+	}
+	return nil
+}
+
+// hold moves the unit out of the active and backoff queues, keeping its
+// original queueing metadata so that it can be released later.
+func (p *PrioritySchedulingQueue) hold(key string, newInfo *framework.QueueUnitInfo) error {
+	if info, ok := p.held[key]; ok {
+		info.Unit = newInfo.Unit
+		return nil
+	}
+	if info, ok := p.unschedulableQ[key]; ok {
+		delete(p.unschedulableQ, key)
+		info.Unit = newInfo.Unit
+		p.held[key] = info
+		return nil
+	}
+	for _, h := range []*heap.Heap{p.items, p.backoffQ} {
+		obj, ok, _ := h.GetByKey(key)
+		if !ok {
+			continue
+		}
+		if err := h.Delete(obj); err != nil {
+			return err
+		}
+		info := obj.(*framework.QueueUnitInfo)
+		info.Unit = newInfo.Unit
+		p.held[key] = info
+		return nil
+	}
+	return nil
+}
+
+// release moves a held unit back to the active queue. InitialAttemptTimestamp
+// is preserved so the unit returns to its original position.
+func (p *PrioritySchedulingQueue) release(info *framework.QueueUnitInfo, new *v1alpha1.QueueUnit) error {
+	delete(p.held, info.Name)
+	info.Unit = new
+	if err := p.items.Add(info); err != nil {
 		return err
 	}
+	p.notify()
 	return nil
 }
 
@@ -168,19 +387,76 @@ func (p *PrioritySchedulingQueue) Pop() (*framework.QueueUnitInfo, error) {
 	p.Lock()
 	defer p.Unlock()
 
+	if p.items.Len() == 0 {
+		return nil, fmt.Errorf("queue is empty")
+	}
 	obj, err := p.items.Pop()
+	if err != nil {
+		return nil, err
+	}
 	u := obj.(*framework.QueueUnitInfo)
-	return u, err
+	return u, nil
+}
+
+func (p *PrioritySchedulingQueue) Restore(info *framework.QueueUnitInfo) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if _, ok := p.held[info.Name]; ok {
+		return nil
+	}
+	if utils.IsHeld(info.Unit) {
+		p.held[info.Name] = info
+		return nil
+	}
+	return p.items.AddIfNotPresent(info)
+}
+
+func (p *PrioritySchedulingQueue) Resort() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.items.Reheapify()
 }
 
+func (p *PrioritySchedulingQueue) MoveAllToActiveQueue(event framework.ClusterEvent) {
+	p.Lock()
+	defer p.Unlock()
+	// the event may also make the head kept in place by a blocking or backfill
+	// queue schedulable
+	defer p.notify()
+
+	for _, obj := range p.backoffQ.List() {
+		info := obj.(*framework.QueueUnitInfo)
+		if !p.fw.IsEventRelevant(event, info.UnschedulablePlugins) {
+			continue
+		}
+		if err := p.backoffQ.Delete(info); err != nil {
+			klog.Errorf("Unable to delete %v from backoff queue on event %v: %v", info.Name, event, err)
+			continue
+		}
+		if err := p.items.Add(info); err != nil {
+			klog.Errorf("Unable to add %v to active queue on event %v: %v", info.Name, event, err)
+		}
+	}
+	for key, info := range p.unschedulableQ {
+		if !p.fw.IsEventRelevant(event, info.UnschedulablePlugins) {
+			continue
+		}
+		delete(p.unschedulableQ, key)
+		if err := p.items.Add(info); err != nil {
+			klog.Errorf("Unable to add %v to active queue on event %v: %v", info.Name, event, err)
+		}
+	}
+}
+
+// TopUnit returns the head of the active queue without removing it
 func (p *PrioritySchedulingQueue) TopUnit() (*framework.QueueUnitInfo, error) {
 	p.Lock()
 	defer p.Unlock()
 
-	if p.items.Len() > 0 {
-		obj := p.items.List()[0]
-		u := obj.(*framework.QueueUnitInfo)
-		return u, nil
+	if obj := p.items.Peek(); obj != nil {
+		return obj.(*framework.QueueUnitInfo), nil
 	}
 	return nil, fmt.Errorf("queue is empty")
 }
@@ -190,9 +466,111 @@ func (p *PrioritySchedulingQueue) Name() string {
 }
 
 func (p *PrioritySchedulingQueue) QueueInfo() *framework.QueueInfo {
+	p.RLock()
+	defer p.RUnlock()
+
 	return p.queue
 }
 
+func (p *PrioritySchedulingQueue) Units() []*framework.QueueUnitInfo {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.units()
+}
+
+// units returns all the pending units of the queue. The caller must hold the lock.
+func (p *PrioritySchedulingQueue) units() []*framework.QueueUnitInfo {
+	units := make([]*framework.QueueUnitInfo, 0, p.items.Len()+p.backoffQ.Len()+len(p.held)+len(p.unschedulableQ))
+	for _, h := range []*heap.Heap{p.items, p.backoffQ} {
+		for _, obj := range h.List() {
+			units = append(units, obj.(*framework.QueueUnitInfo))
+		}
+	}
+	for _, m := range []map[string]*framework.QueueUnitInfo{p.held, p.unschedulableQ} {
+		for _, info := range m {
+			units = append(units, info)
+		}
+	}
+	return units
+}
+
+func (p *PrioritySchedulingQueue) UpdateQueue(q *v1alpha1.Queue) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if pluginName := string(q.Spec.QueuePolicy); pluginName != p.pluginName {
+		if err := p.setPolicy(pluginName); err != nil {
+			return err
+		}
+	}
+	p.queue = framework.NewQueueInfo(q)
+	p.windows = parseAdmissionWindows(q)
+	p.notify()
+	return nil
+}
+
+// setPolicy re-sorts the active queue with the given plugin, keeping the queueing
+// metadata of all the units. The pending units leave the old plugin and are added to
+// the new one in the order they were queued. An unknown policy is rejected and the
+// queue is left untouched. The caller must hold the lock.
+func (p *PrioritySchedulingQueue) setPolicy(pluginName string) error {
+	lessFn, err := compareFunc(p.fw, p.name, pluginName)
+	if err != nil {
+		return err
+	}
+	klog.Infof("queue %s policy changed from %s to %s", p.name, p.pluginName, pluginName)
+	units := p.units()
+	sort.SliceStable(units, func(i, j int) bool {
+		return units[i].InitialAttemptTimestamp.Before(units[j].InitialAttemptTimestamp)
+	})
+	for _, info := range units {
+		p.fw.RunQueueUnitDeleted(p.pluginName, info)
+	}
+	for _, info := range units {
+		p.fw.RunQueueUnitAdded(pluginName, info)
+	}
+	items := heap.New(unitInfoKeyFunc, lessFn)
+	for _, obj := range p.items.List() {
+		if err := items.Add(obj); err != nil {
+			klog.Errorf("Unable to re-sort %v: %v", obj.(*framework.QueueUnitInfo).Name, err)
+		}
+	}
+	p.items = items
+	p.pluginName = pluginName
+	p.refreshInterval = p.fw.QueueSortRefreshInterval(pluginName)
+	if p.run {
+		p.startRefresh()
+	}
+	return nil
+}
+
+func (p *PrioritySchedulingQueue) Admissible() bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.windows.Open(p.clock.Now())
+}
+
+func (p *PrioritySchedulingQueue) NextAdmissionTime() time.Time {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.windows.NextOpening(p.clock.Now())
+}
+
+// parseAdmissionWindows returns the admission windows of the queue. A queue with
+// invalid windows is closed, rather than admitting units at any time, until its
+// windows are fixed.
+func parseAdmissionWindows(q *v1alpha1.Queue) *admissionwindow.Windows {
+	windows, err := admissionwindow.Parse(q)
+	if err != nil {
+		klog.Errorf("queue %s/%s has invalid admission windows, closed: %v", q.Namespace, q.Name, err)
+		return admissionwindow.Closed()
+	}
+	return windows
+}
+
 func (p *PrioritySchedulingQueue) Length() int {
 	return p.items.Len()
 }
@@ -227,6 +605,27 @@ func (p *PrioritySchedulingQueue) flushBackoffQCompleted() {
 			klog.Errorf("Unable to add pod %v back to active queue despite backoff completion.", qu.Unit.Namespace+"/"+qu.Unit.Name)
 			return
 		}
+		p.notify()
+	}
+}
+
+// flushUnschedulableQLeftover moves the units which stay in unschedulableQ longer
+// than podMaxInUnschedulableQDuration to the active queue
+func (p *PrioritySchedulingQueue) flushUnschedulableQLeftover() {
+	p.Lock()
+	defer p.Unlock()
+
+	now := p.clock.Now()
+	for key, info := range p.unschedulableQ {
+		if now.Sub(info.Timestamp) < p.podMaxInUnschedulableQDuration {
+			continue
+		}
+		delete(p.unschedulableQ, key)
+		if err := p.items.Add(info); err != nil {
+			klog.Errorf("Unable to add %v back to active queue from unschedulable queue: %v", key, err)
+			continue
+		}
+		p.notify()
 	}
 }
 
@@ -0,0 +1,476 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package schedulingqueue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/informers"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/priority"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/shortestjobfirst"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/userfairness"
+	"github.com/kube-queue/kube-queue/pkg/framework/runtime"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+func newTestQueue(t *testing.T) *PrioritySchedulingQueue {
+	fw, err := runtime.NewFramework(runtime.Registry{priority.Name: priority.New}, nil, "", informers.NewSharedInformerFactory(nil, 0), nil, nil)
+	if err != nil {
+		t.Fatalf("new framework failed %v", err)
+	}
+	q := &v1alpha1.Queue{
+		ObjectMeta: metav1.ObjectMeta{Name: "queue", Namespace: "ns"},
+		Spec:       v1alpha1.QueueSpec{QueuePolicy: v1alpha1.QueuePolicyPriority},
+	}
+	pq, err := NewPrioritySchedulingQueue(fw, "ns", priority.Name, 1, 20, 30, 300, q, nil)
+	if err != nil {
+		t.Fatalf("new queue failed %v", err)
+	}
+	return pq.(*PrioritySchedulingQueue)
+}
+
+func TestHoldAndRelease(t *testing.T) {
+	q := newTestQueue(t)
+	for _, name := range []string{"qu1", "qu2", "qu3"} {
+		if err := q.Add(makeQueueUnit(name, 10)); err != nil {
+			t.Fatalf("add %s failed %v", name, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	obj, _, _ := q.items.GetByKey("ns/qu1")
+	initial := obj.(*framework.QueueUnitInfo).InitialAttemptTimestamp
+
+	held := makeQueueUnit("qu1", 10)
+	held.Annotations = map[string]string{utils.AnnotationHold: "true"}
+	if err := q.Update(makeQueueUnit("qu1", 10), held); err != nil {
+		t.Fatalf("hold failed %v", err)
+	}
+	if q.Length() != 2 {
+		t.Fatalf("expected 2 active units, got %d", q.Length())
+	}
+	top, _ := q.TopUnit()
+	if top.Name == "ns/qu1" {
+		t.Fatalf("held unit should not be at the top of the queue")
+	}
+
+	if err := q.Update(held, makeQueueUnit("qu1", 10)); err != nil {
+		t.Fatalf("release failed %v", err)
+	}
+	if q.Length() != 3 {
+		t.Fatalf("expected 3 active units, got %d", q.Length())
+	}
+	info, err := q.Pop()
+	if err != nil {
+		t.Fatalf("pop failed %v", err)
+	}
+	if info.Name != "ns/qu1" {
+		t.Errorf("expected released unit ns/qu1 to keep its place, got %s", info.Name)
+	}
+	if !info.InitialAttemptTimestamp.Equal(initial) {
+		t.Errorf("InitialAttemptTimestamp changed from %v to %v", initial, info.InitialAttemptTimestamp)
+	}
+}
+
+func TestAddHeldUnit(t *testing.T) {
+	q := newTestQueue(t)
+	unit := makeQueueUnit("qu1", 10)
+	unit.Annotations = map[string]string{utils.AnnotationHold: "true"}
+	if err := q.Add(unit); err != nil {
+		t.Fatalf("add failed %v", err)
+	}
+	if q.Length() != 0 {
+		t.Errorf("expected held unit to stay out of the active queue, got length %d", q.Length())
+	}
+	if err := q.Delete(unit); err != nil {
+		t.Fatalf("delete failed %v", err)
+	}
+	if len(q.held) != 0 {
+		t.Errorf("expected held unit to be deleted")
+	}
+}
+
+func TestRestore(t *testing.T) {
+	q := newTestQueue(t)
+	for _, name := range []string{"qu1", "qu2"} {
+		if err := q.Add(makeQueueUnit(name, 10)); err != nil {
+			t.Fatalf("add %s failed %v", name, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	head, err := q.Pop()
+	if err != nil {
+		t.Fatalf("pop failed %v", err)
+	}
+	head.BlockedSince = time.Now()
+	if err := q.Restore(head); err != nil {
+		t.Fatalf("restore failed %v", err)
+	}
+	if q.Length() != 2 {
+		t.Fatalf("expected 2 active units, got %d", q.Length())
+	}
+	info, err := q.Pop()
+	if err != nil {
+		t.Fatalf("pop failed %v", err)
+	}
+	if info != head {
+		t.Errorf("expected restored unit %s to keep its place, got %s", head.Name, info.Name)
+	}
+	if _, err := q.Pop(); err != nil {
+		t.Fatalf("pop failed %v", err)
+	}
+	if _, err := q.Pop(); err == nil {
+		t.Errorf("expected error when popping an empty queue")
+	}
+}
+
+func TestUpdateKeepsMetadata(t *testing.T) {
+	q := newTestQueue(t)
+	old := makeQueueUnit("qu1", 10)
+	if err := q.Add(old); err != nil {
+		t.Fatalf("add failed %v", err)
+	}
+	info := q.Units()[0]
+	info.Attempts = 3
+	initial := info.InitialAttemptTimestamp
+
+	new := makeQueueUnit("qu1", 20)
+	if err := q.Update(old, new); err != nil {
+		t.Fatalf("update failed %v", err)
+	}
+	if err := q.Add(new); err != nil {
+		t.Fatalf("add again failed %v", err)
+	}
+	units := q.Units()
+	if len(units) != 1 {
+		t.Fatalf("expected 1 pending unit, got %d", len(units))
+	}
+	if units[0].Unit != new {
+		t.Errorf("expected the unit to be refreshed")
+	}
+	if units[0].Attempts != 3 || !units[0].InitialAttemptTimestamp.Equal(initial) {
+		t.Errorf("expected the queueing metadata to be kept, got %d attempts since %v", units[0].Attempts, units[0].InitialAttemptTimestamp)
+	}
+}
+
+type fakeFilterPlugin struct{}
+
+func (f *fakeFilterPlugin) Name() string {
+	return "Fake"
+}
+
+func (f *fakeFilterPlugin) Filter(ctx context.Context, unit *framework.QueueUnitInfo) *framework.Status {
+	return framework.NewStatus(framework.Unschedulable, "")
+}
+
+func (f *fakeFilterPlugin) EventsToRegister() []framework.ClusterEvent {
+	return []framework.ClusterEvent{framework.QueueUpdated}
+}
+
+func TestMoveAllToActiveQueue(t *testing.T) {
+	registry := runtime.Registry{
+		priority.Name: priority.New,
+		"Fake": func(_ k8sruntime.Object, _ framework.Handle) (framework.Plugin, error) {
+			return &fakeFilterPlugin{}, nil
+		},
+	}
+	fw, err := runtime.NewFramework(registry, nil, "", informers.NewSharedInformerFactory(nil, 0), nil, nil)
+	if err != nil {
+		t.Fatalf("new framework failed %v", err)
+	}
+	pq, err := NewPrioritySchedulingQueue(fw, "ns", priority.Name, 1, 20, 30, 300, &v1alpha1.Queue{
+		ObjectMeta: metav1.ObjectMeta{Name: "queue", Namespace: "ns"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("new queue failed %v", err)
+	}
+	q := pq.(*PrioritySchedulingQueue)
+
+	for _, name := range []string{"qu1", "qu2"} {
+		if err := q.Add(makeQueueUnit(name, 10)); err != nil {
+			t.Fatalf("add %s failed %v", name, err)
+		}
+	}
+	for _, plugin := range []string{"Fake", "Unknown"} {
+		info, err := q.Pop()
+		if err != nil {
+			t.Fatalf("pop failed %v", err)
+		}
+		info.UnschedulablePlugins = sets.NewString(plugin)
+		if err := q.AddUnschedulableIfNotPresent(info); err != nil {
+			t.Fatalf("add unschedulable %s failed %v", info.Name, err)
+		}
+	}
+
+	q.MoveAllToActiveQueue(framework.QueueUnitDeleted)
+	if q.Length() != 1 {
+		t.Fatalf("expected only the unit rejected by a plugin without events to move, got %d active units", q.Length())
+	}
+	q.MoveAllToActiveQueue(framework.QueueUpdated)
+	if q.Length() != 2 {
+		t.Errorf("expected the unit rejected by Fake to move on QueueUpdated, got %d active units", q.Length())
+	}
+	if q.backoffQ.Len() != 0 {
+		t.Errorf("expected backoff queue to be empty, got %d units", q.backoffQ.Len())
+	}
+}
+
+func TestUnschedulableQ(t *testing.T) {
+	q := newTestQueue(t)
+	fakeClock := clock.NewFakeClock(time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC))
+	q.clock = fakeClock
+	if err := q.Add(makeQueueUnit("qu1", 10)); err != nil {
+		t.Fatalf("add failed %v", err)
+	}
+	info, err := q.Pop()
+	if err != nil {
+		t.Fatalf("pop failed %v", err)
+	}
+	info.Timestamp = fakeClock.Now()
+	info.Unresolvable = true
+	if err := q.AddUnschedulableIfNotPresent(info); err != nil {
+		t.Fatalf("add unschedulable failed %v", err)
+	}
+	if len(q.unschedulableQ) != 1 || q.backoffQ.Len() != 0 {
+		t.Fatalf("expected unresolvable unit in unschedulableQ, got %d in unschedulableQ and %d in backoffQ", len(q.unschedulableQ), q.backoffQ.Len())
+	}
+
+	fakeClock.Step(time.Minute)
+	q.flushUnschedulableQLeftover()
+	if q.Length() != 0 {
+		t.Fatalf("expected unit to stay in unschedulableQ before the flush interval")
+	}
+	fakeClock.Step(q.podMaxInUnschedulableQDuration)
+	q.flushUnschedulableQLeftover()
+	if q.Length() != 1 || len(q.unschedulableQ) != 0 {
+		t.Errorf("expected unit to be flushed to the active queue after the flush interval")
+	}
+}
+
+func TestUpdateQueuePolicy(t *testing.T) {
+	registry := runtime.Registry{
+		priority.Name:         priority.New,
+		shortestjobfirst.Name: shortestjobfirst.New,
+	}
+	fw, err := runtime.NewFramework(registry, nil, "", informers.NewSharedInformerFactory(nil, 0), nil, nil)
+	if err != nil {
+		t.Fatalf("new framework failed %v", err)
+	}
+	queue := &v1alpha1.Queue{
+		ObjectMeta: metav1.ObjectMeta{Name: "queue", Namespace: "ns"},
+		Spec:       v1alpha1.QueueSpec{QueuePolicy: v1alpha1.QueuePolicyPriority},
+	}
+	pq, err := NewPrioritySchedulingQueue(fw, "ns/queue", priority.Name, 1, 20, 30, 300, queue, nil)
+	if err != nil {
+		t.Fatalf("new queue failed %v", err)
+	}
+	q := pq.(*PrioritySchedulingQueue)
+
+	long := makeQueueUnit("long", 10)
+	long.Annotations = map[string]string{utils.AnnotationExpectedRuntime: "2h"}
+	short := makeQueueUnit("short", 1)
+	short.Annotations = map[string]string{utils.AnnotationExpectedRuntime: "10m"}
+	for _, unit := range []*v1alpha1.QueueUnit{long, short} {
+		if err := q.Add(unit); err != nil {
+			t.Fatalf("add %s failed %v", unit.Name, err)
+		}
+	}
+	obj, _, _ := q.items.GetByKey("ns/short")
+	obj.(*framework.QueueUnitInfo).Attempts = 3
+	initial := obj.(*framework.QueueUnitInfo).InitialAttemptTimestamp
+
+	if top, _ := q.TopUnit(); top.Name != "ns/long" {
+		t.Fatalf("expected unit with higher priority at the top, got %s", top.Name)
+	}
+
+	queue = queue.DeepCopy()
+	queue.Spec.QueuePolicy = shortestjobfirst.Name
+	if err := q.UpdateQueue(queue); err != nil {
+		t.Fatalf("update queue failed %v", err)
+	}
+	if q.Length() != 2 {
+		t.Fatalf("expected 2 active units after policy change, got %d", q.Length())
+	}
+	info, err := q.Pop()
+	if err != nil {
+		t.Fatalf("pop failed %v", err)
+	}
+	if info.Name != "ns/short" {
+		t.Errorf("expected shortest unit at the top after policy change, got %s", info.Name)
+	}
+	if info.Attempts != 3 || !info.InitialAttemptTimestamp.Equal(initial) {
+		t.Errorf("expected queueing metadata to be kept, got attempts %d and initial attempt %v", info.Attempts, info.InitialAttemptTimestamp)
+	}
+}
+
+func TestUpdateQueuePolicyToUserFairness(t *testing.T) {
+	registry := runtime.Registry{
+		priority.Name:     priority.New,
+		userfairness.Name: userfairness.New,
+	}
+	fw, err := runtime.NewFramework(registry, nil, "", informers.NewSharedInformerFactory(nil, 0), nil, nil)
+	if err != nil {
+		t.Fatalf("new framework failed %v", err)
+	}
+	queue := &v1alpha1.Queue{
+		ObjectMeta: metav1.ObjectMeta{Name: "queue", Namespace: "ns"},
+		Spec:       v1alpha1.QueueSpec{QueuePolicy: v1alpha1.QueuePolicyPriority},
+	}
+	pq, err := NewPrioritySchedulingQueue(fw, "ns/queue", priority.Name, 1, 20, 30, 300, queue, nil)
+	if err != nil {
+		t.Fatalf("new queue failed %v", err)
+	}
+	q := pq.(*PrioritySchedulingQueue)
+
+	start := time.Now().Add(-time.Minute)
+	for i, name := range []string{"a1", "a2", "a3", "b1"} {
+		unit := makeQueueUnit(name, 0)
+		unit.Annotations = map[string]string{utils.AnnotationCreator: name[:1]}
+		info := framework.NewQueueUnitInfo(unit)
+		info.InitialAttemptTimestamp = start.Add(time.Duration(i) * time.Second)
+		if err := q.AddQueueUnitInfo(info); err != nil {
+			t.Fatalf("add %s failed %v", name, err)
+		}
+	}
+
+	// the units queued before the switch are interleaved like the ones queued after
+	queue = queue.DeepCopy()
+	queue.Spec.QueuePolicy = userfairness.Name
+	if err := q.UpdateQueue(queue); err != nil {
+		t.Fatalf("update queue failed %v", err)
+	}
+	late := makeQueueUnit("c1", 0)
+	late.Annotations = map[string]string{utils.AnnotationCreator: "c"}
+	if err := q.Add(late); err != nil {
+		t.Fatalf("add c1 failed %v", err)
+	}
+
+	var got []string
+	for q.Length() > 0 {
+		info, err := q.Pop()
+		if err != nil {
+			t.Fatalf("pop failed %v", err)
+		}
+		got = append(got, info.Unit.Name)
+	}
+	want := []string{"a1", "b1", "c1", "a2", "a3"}
+	if len(got) != len(want) {
+		t.Fatalf("got order %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got order %v, want %v", got, want)
+		}
+	}
+}
+
+func TestQueuePolicy(t *testing.T) {
+	fw, err := runtime.NewFramework(runtime.Registry{priority.Name: priority.New}, nil, "", informers.NewSharedInformerFactory(nil, 0), nil, nil)
+	if err != nil {
+		t.Fatalf("new framework failed %v", err)
+	}
+	queue := &v1alpha1.Queue{
+		ObjectMeta: metav1.ObjectMeta{Name: "queue", Namespace: "ns"},
+		Spec:       v1alpha1.QueueSpec{QueuePolicy: v1alpha1.QueuePolicyFIFO},
+	}
+	pq, err := NewPrioritySchedulingQueue(fw, "ns/queue", string(v1alpha1.QueuePolicyFIFO), 1, 20, 30, 300, queue, nil)
+	if err != nil {
+		t.Fatalf("new FIFO queue failed %v", err)
+	}
+
+	// a FIFO queue ignores the priority of the units
+	for _, unit := range []*v1alpha1.QueueUnit{makeQueueUnit("low", 1), makeQueueUnit("high", 100)} {
+		if err := pq.Add(unit); err != nil {
+			t.Fatalf("add %s failed %v", unit.Name, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if info, _ := pq.Pop(); info.Unit.Name != "low" {
+		t.Errorf("expected the first queued unit to be popped first, got %s", info.Unit.Name)
+	}
+
+	// unknown policies are rejected
+	unknown := queue.DeepCopy()
+	unknown.Spec.QueuePolicy = "Unknown"
+	if _, err := NewPrioritySchedulingQueue(fw, "ns/queue", string(unknown.Spec.QueuePolicy), 1, 20, 30, 300, unknown, nil); err == nil {
+		t.Errorf("expected a queue with an unknown policy to be rejected")
+	}
+	if err := pq.UpdateQueue(unknown); err == nil {
+		t.Errorf("expected the update to an unknown policy to be rejected")
+	}
+	if got := pq.QueueInfo().Queue.Spec.QueuePolicy; got != v1alpha1.QueuePolicyFIFO {
+		t.Errorf("expected the rejected update to leave the queue untouched, got policy %s", got)
+	}
+}
+
+func TestAdmissionWindows(t *testing.T) {
+	q := newTestQueue(t)
+	fakeClock := clock.NewFakeClock(time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC))
+	q.clock = fakeClock
+	if !q.Admissible() {
+		t.Fatalf("queue without admission windows should always be admissible")
+	}
+
+	queue := q.QueueInfo().Queue.DeepCopy()
+	queue.Annotations = map[string]string{utils.AnnotationAdmissionWindows: "0 22 * * * 8h"}
+	if err := q.UpdateQueue(queue); err != nil {
+		t.Fatalf("update queue failed %v", err)
+	}
+	if q.Admissible() {
+		t.Errorf("queue should not be admissible outside of its windows")
+	}
+	if want := time.Date(2021, 10, 1, 22, 0, 0, 0, time.UTC); !q.NextAdmissionTime().Equal(want) {
+		t.Errorf("NextAdmissionTime() = %v, want %v", q.NextAdmissionTime(), want)
+	}
+
+	fakeClock.SetTime(time.Date(2021, 10, 1, 23, 0, 0, 0, time.UTC))
+	if !q.Admissible() {
+		t.Errorf("queue should be admissible inside its windows")
+	}
+
+	queue = queue.DeepCopy()
+	queue.Annotations[utils.AnnotationAdmissionWindows] = "0 22 * * * 8"
+	if err := q.UpdateQueue(queue); err != nil {
+		t.Fatalf("update queue failed %v", err)
+	}
+	if q.Admissible() {
+		t.Errorf("queue with invalid admission windows should not be admissible")
+	}
+	if !q.NextAdmissionTime().IsZero() {
+		t.Errorf("queue with invalid admission windows should never open, got %v", q.NextAdmissionTime())
+	}
+}
+
+func makeQueueUnit(name string, priority int32) *v1alpha1.QueueUnit {
+	return &v1alpha1.QueueUnit{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "ns",
+		},
+		Spec: v1alpha1.QueueUnitSpec{
+			Priority: &priority,
+		},
+	}
+}
@@ -18,92 +18,300 @@ package scheduler
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 
 	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
 	"github.com/kube-queue/api/pkg/client/clientset/versioned"
 	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/metrics"
 	"github.com/kube-queue/kube-queue/pkg/queue"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+const (
+	// DefaultBackfillReservation is the reservation of the blocked head of a backfill
+	// queue without AnnotationBackfillReservation.
+	DefaultBackfillReservation = 30 * time.Minute
+	// maxBackfillUnits is the maximum number of units behind the blocked head
+	// considered for backfill in a scheduling cycle.
+	maxBackfillUnits = 100
+	// idleTimeout is the maximum time the scheduler waits for units to become
+	// schedulable after a cycle that dequeued nothing, so that admission windows
+	// opening and heads kept in place are retried.
+	idleTimeout = time.Second
 )
 
 type Scheduler struct {
 	multiSchedulingQueue queue.MultiSchedulingQueue
 	fw                   framework.Framework
-	QueueClient          *versioned.Clientset
+	QueueClient          versioned.Interface
+	// parallelism is the number of quota scopes scheduled concurrently
+	parallelism int
+	// nextAdmission records the next admission window reported for each queue
+	nextAdmission     map[string]string
+	nextAdmissionLock sync.Mutex
+	// admissionReports are the next admission windows to be reported, they are
+	// reported off the scheduling cycle
+	admissionReports workqueue.Interface
 }
 
-func NewScheduler(multiSchedulingQueue queue.MultiSchedulingQueue, fw framework.Framework, queueClient *versioned.Clientset) (*Scheduler, error) {
+// admissionReport is the next opening of the admission windows of a Queue to be
+// reported in its annotations
+type admissionReport struct {
+	namespace string
+	name      string
+	value     string
+}
+
+func NewScheduler(multiSchedulingQueue queue.MultiSchedulingQueue, fw framework.Framework, queueClient versioned.Interface, parallelism int) (*Scheduler, error) {
+	if parallelism < 1 {
+		parallelism = 1
+	}
 	sche := &Scheduler{
 		multiSchedulingQueue: multiSchedulingQueue,
 		fw:                   fw,
 		QueueClient:          queueClient,
+		parallelism:          parallelism,
+		nextAdmission:        make(map[string]string),
+		admissionReports:     workqueue.NewNamed("admission-window"),
 	}
 	return sche, nil
 }
 
 func (s *Scheduler) Start(ctx context.Context) {
+	go wait.UntilWithContext(ctx, s.reportNextAdmissionWindows, time.Second)
+	defer s.admissionReports.ShutDown()
 	s.internalSchedule(ctx)
 }
 
-// Internal start scheduling
+// Internal start scheduling, it returns when ctx is done. When a cycle dequeues
+// nothing, it waits until a unit may become schedulable before the next cycle.
 func (s *Scheduler) internalSchedule(ctx context.Context) {
 	for {
-		s.schedule(ctx)
+		select {
+		case <-ctx.Done():
+			klog.Infof("scheduler stopped")
+			return
+		default:
+		}
+		start := time.Now()
+		scheduled := s.schedule(ctx)
+		metrics.SchedulingCycleDuration.Since(start)
+		if !scheduled {
+			s.multiSchedulingQueue.Wait(ctx, idleTimeout)
+		}
 	}
 }
 
-func (s *Scheduler) schedule(ctx context.Context) {
+// schedule runs a scheduling cycle over all the queues, it returns true if any unit
+// passed the filters. The queues sharing a quota scope are scheduled in order by
+// the same worker, while independent quota scopes are scheduled concurrently.
+func (s *Scheduler) schedule(ctx context.Context) bool {
 	schedulingCycleCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	sortedQueue := s.multiSchedulingQueue.SortedQueue()
-	for _, q := range sortedQueue {
-		if q.Length() > 0 {
-			unitInfo, err := q.Pop()
-			if err != nil {
-				klog.Errorf("get topunit err %v", err)
+	var scheduled int32
+	groups := groupByQuotaScope(s.multiSchedulingQueue.SortedQueue())
+	workqueue.ParallelizeUntil(ctx, s.parallelism, len(groups), func(i int) {
+		for _, q := range groups[i] {
+			if s.scheduleQueue(ctx, schedulingCycleCtx, q) {
+				atomic.StoreInt32(&scheduled, 1)
 			}
-			klog.Info("---schedule begin %v ---", unitInfo.Name)
-			status := s.fw.RunFilterPlugins(schedulingCycleCtx, unitInfo)
-			klog.Info("filter status %v %v", status.Code(), status.Message())
-			if status.Code() == framework.Success {
-				klog.Infof("dequeue %v", unitInfo.Name)
-				status = s.fw.RunReservePluginsReserve(schedulingCycleCtx, unitInfo)
-				klog.Info("reserve status %v %v", status.Code(), status.Message())
-				if status.Code() != framework.Success {
-					s.ErrorFunc(ctx, unitInfo, q)
-					klog.Info("---schedule end %v ---", unitInfo.Name)
-					continue
-				}
-				go func() {
-					err := s.Dequeue(unitInfo.Unit)
-					if err != nil {
-						klog.Errorf("dequeue %v failed: %v", unitInfo.Name, err.Error())
-						// 构建一个临时存储的位置
-						s.fw.RunReservePluginsUnreserve(schedulingCycleCtx, unitInfo)
-						s.ErrorFunc(ctx, unitInfo, q)
-						return
-					}
-					klog.Info("dequeue %v success", unitInfo.Name)
-					klog.Info("---schedule end %v ---", unitInfo.Name)
-				}()
-			} else {
-				s.ErrorFunc(ctx, unitInfo, q)
-				klog.Info("---schedule end %v ---", unitInfo.Name)
+		}
+	})
+	return atomic.LoadInt32(&scheduled) == 1
+}
+
+// groupByQuotaScope groups the sorted queues by the namespaces whose ResourceQuota
+// their units charge, keeping the order of the queues in each group. The units of a
+// queue charge the namespace of their consumer, which differs from the namespace of
+// the queue for shared queues, so two queues with units charging a same namespace
+// are in the same group. The units added during the cycle may still charge the
+// namespace of another group, the reserve plugins check the quotas again for them.
+func groupByQuotaScope(sortedQueue []queue.SchedulingQueue) [][]queue.SchedulingQueue {
+	parent := make(map[string]string)
+	var find func(scope string) string
+	find = func(scope string) string {
+		p, ok := parent[scope]
+		if !ok || p == scope {
+			parent[scope] = scope
+			return scope
+		}
+		root := find(p)
+		parent[scope] = root
+		return root
+	}
+	for _, q := range sortedQueue {
+		root := find(q.QueueInfo().Queue.Namespace)
+		for _, unitInfo := range q.Units() {
+			parent[find(utils.QueueUnitNamespace(unitInfo.Unit))] = root
+		}
+	}
+
+	groups := make([][]queue.SchedulingQueue, 0)
+	index := make(map[string]int)
+	for _, q := range sortedQueue {
+		scope := find(q.QueueInfo().Queue.Namespace)
+		i, ok := index[scope]
+		if !ok {
+			i = len(groups)
+			index[scope] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], q)
+	}
+	return groups
+}
+
+// scheduleQueue tries to dequeue the head of the queue, it returns true if any unit
+// passed the filters.
+func (s *Scheduler) scheduleQueue(ctx context.Context, schedulingCycleCtx context.Context, q queue.SchedulingQueue) bool {
+	s.updateNextAdmissionWindow(q)
+	if !q.Admissible() || q.Length() == 0 {
+		return false
+	}
+	unitInfo, err := q.Pop()
+	if err != nil {
+		klog.Errorf("get topunit err %v", err)
+		return false
+	}
+	klog.Info("---schedule begin %v ---", unitInfo.Name)
+	if s.dequeueIfFit(ctx, schedulingCycleCtx, q, unitInfo) {
+		return true
+	}
+	scheduled := false
+	queueObj := q.QueueInfo().Queue
+	switch {
+	case utils.IsBlockingEnabled(queueObj):
+		s.block(ctx, q, unitInfo)
+	case utils.IsBackfillEnabled(queueObj):
+		scheduled = s.backfill(ctx, schedulingCycleCtx, q, unitInfo)
+	default:
+		s.ErrorFunc(ctx, unitInfo, q)
+	}
+	klog.Info("---schedule end %v ---", unitInfo.Name)
+	return scheduled
+}
+
+// dequeueIfFit runs the filter plugins for the unit, and reserves and dequeues it
+// if they all succeed. It returns false if the unit does not pass the filters.
+func (s *Scheduler) dequeueIfFit(ctx context.Context, schedulingCycleCtx context.Context, q queue.SchedulingQueue, unitInfo *framework.QueueUnitInfo) bool {
+	status := s.fw.RunFilterPlugins(schedulingCycleCtx, unitInfo)
+	klog.Info("filter status %v %v", status.Code(), status.Message())
+	if status.Code() != framework.Success {
+		unitInfo.UnschedulablePlugins = sets.NewString(status.FailedPlugin())
+		unitInfo.Unresolvable = status.Code() == framework.UnschedulableAndUnresolvable
+		return false
+	}
+	unitInfo.UnschedulablePlugins = nil
+	unitInfo.Unresolvable = false
+	unitInfo.BlockedSince = time.Time{}
+	klog.Infof("dequeue %v", unitInfo.Name)
+	status = s.fw.RunReservePluginsReserve(schedulingCycleCtx, unitInfo)
+	klog.Info("reserve status %v %v", status.Code(), status.Message())
+	if status.Code() != framework.Success {
+		s.ErrorFunc(ctx, unitInfo, q)
+		klog.Info("---schedule end %v ---", unitInfo.Name)
+		return true
+	}
+	go func(q queue.SchedulingQueue) {
+		err := s.Dequeue(unitInfo)
+		if err != nil {
+			klog.Errorf("dequeue %v failed: %v", unitInfo.Name, err.Error())
+			// 构建一个临时存储的位置
+			s.fw.RunReservePluginsUnreserve(schedulingCycleCtx, unitInfo)
+			s.ErrorFunc(ctx, unitInfo, q)
+			return
+		}
+		klog.Info("dequeue %v success", unitInfo.Name)
+		klog.Info("---schedule end %v ---", unitInfo.Name)
+	}(q)
+	return true
+}
+
+// block keeps the head of a blocking queue in its place, so that no unit behind
+// it is considered until it fits. Once it has been blocking the queue for longer
+// than the blocking timeout of the queue, it backs off like in other queues.
+func (s *Scheduler) block(ctx context.Context, q queue.SchedulingQueue, head *framework.QueueUnitInfo) {
+	now := time.Now()
+	if head.BlockedSince.IsZero() {
+		head.BlockedSince = now
+	}
+	if timeout, ok := utils.BlockingTimeout(q.QueueInfo().Queue); ok && now.Sub(head.BlockedSince) >= timeout {
+		klog.Infof("%v has blocked queue %v for more than %v, back off", head.Name, q.Name(), timeout)
+		// the unit blocks the queue again for the whole timeout when it is retried
+		head.BlockedSince = time.Time{}
+		s.ErrorFunc(ctx, head, q)
+		return
+	}
+	if err := q.Restore(head); err != nil {
+		klog.Errorf("restore %v failed %v", head.Name, err)
+	}
+}
+
+// backfill keeps the blocked head of a backfill queue in its place, and dequeues
+// the units behind it which fit now and are expected to finish before the
+// reservation of the head, so that the head is not delayed by them. Units without
+// expected runtime are never backfilled. It returns true if any unit is backfilled.
+func (s *Scheduler) backfill(ctx context.Context, schedulingCycleCtx context.Context, q queue.SchedulingQueue, head *framework.QueueUnitInfo) bool {
+	now := time.Now()
+	if head.BlockedSince.IsZero() {
+		head.BlockedSince = now
+	}
+	reservation, ok := utils.BackfillReservation(q.QueueInfo().Queue)
+	if !ok {
+		reservation = DefaultBackfillReservation
+	}
+	deadline := head.BlockedSince.Add(reservation)
+
+	skipped := []*framework.QueueUnitInfo{head}
+	defer func() {
+		for _, unitInfo := range skipped {
+			if err := q.Restore(unitInfo); err != nil {
+				klog.Errorf("restore %v failed %v", unitInfo.Name, err)
 			}
 		}
+	}()
+	if !now.Before(deadline) {
+		return false
 	}
+	backfilled := false
+	for i := 0; i < maxBackfillUnits && q.Length() > 0; i++ {
+		unitInfo, err := q.Pop()
+		if err != nil {
+			break
+		}
+		runtime, ok := utils.ExpectedRuntime(unitInfo.Unit)
+		if !ok || now.Add(runtime).After(deadline) || !s.dequeueIfFit(ctx, schedulingCycleCtx, q, unitInfo) {
+			skipped = append(skipped, unitInfo)
+			continue
+		}
+		klog.Infof("backfill %v before blocked head %v", unitInfo.Name, head.Name)
+		backfilled = true
+	}
+	return backfilled
 }
 
-func (s *Scheduler) Dequeue(queueUnit *v1alpha1.QueueUnit) error {
+func (s *Scheduler) Dequeue(unitInfo *framework.QueueUnitInfo) error {
+	queueUnit := unitInfo.Unit
 	newQueueUnit, err := s.QueueClient.SchedulingV1alpha1().QueueUnits(queueUnit.Namespace).Get(context.TODO(), queueUnit.Name, metav1.GetOptions{})
 	if err != nil {
 		return err
 	}
 
+	if newQueueUnit.Annotations == nil {
+		newQueueUnit.Annotations = make(map[string]string)
+	}
+	newQueueUnit.Annotations[utils.AnnotationInitialAttemptTimestamp] = unitInfo.InitialAttemptTimestamp.Format(time.RFC3339Nano)
+	newQueueUnit.Annotations[utils.AnnotationDequeueTimestamp] = time.Now().Format(time.RFC3339Nano)
 	newQueueUnit.Status.Phase = v1alpha1.Dequeued
 	newQueueUnit.Status.Message = "Dequeued because schedule successfully"
 	_, err = s.QueueClient.SchedulingV1alpha1().QueueUnits(queueUnit.Namespace).Update(context.TODO(), newQueueUnit, metav1.UpdateOptions{})
@@ -115,6 +323,69 @@ func (s *Scheduler) Dequeue(queueUnit *v1alpha1.QueueUnit) error {
 	return nil
 }
 
+// updateNextAdmissionWindow computes the next opening of the admission windows of
+// the queue, and queues its report in the annotations of the Queue if the cached
+// Queue does not have it yet
+func (s *Scheduler) updateNextAdmissionWindow(q queue.SchedulingQueue) {
+	next := q.NextAdmissionTime()
+	s.nextAdmissionLock.Lock()
+	defer s.nextAdmissionLock.Unlock()
+
+	if next.IsZero() {
+		delete(s.nextAdmission, q.Name())
+		return
+	}
+	value := next.Format(time.RFC3339)
+	queueObj := q.QueueInfo().Queue
+	if s.nextAdmission[q.Name()] == value || queueObj.Annotations[utils.AnnotationNextAdmissionWindow] == value {
+		return
+	}
+	s.admissionReports.Add(admissionReport{namespace: queueObj.Namespace, name: queueObj.Name, value: value})
+}
+
+// reportNextAdmissionWindows reports the queued next admission windows until the
+// reports are shut down. A failed report is queued again by the next cycle.
+func (s *Scheduler) reportNextAdmissionWindows(ctx context.Context) {
+	for s.processNextAdmissionReport(ctx) {
+	}
+}
+
+func (s *Scheduler) processNextAdmissionReport(ctx context.Context) bool {
+	obj, shutdown := s.admissionReports.Get()
+	if shutdown {
+		return false
+	}
+	defer s.admissionReports.Done(obj)
+
+	report := obj.(admissionReport)
+	if err := s.reportNextAdmissionWindow(ctx, report); err != nil {
+		klog.Errorf("update next admission window of queue %v/%v error %v", report.namespace, report.name, err)
+		return true
+	}
+	s.nextAdmissionLock.Lock()
+	s.nextAdmission[utils.QueueKey(report.namespace, report.name)] = report.value
+	s.nextAdmissionLock.Unlock()
+	return true
+}
+
+// reportNextAdmissionWindow sets the next admission window in the annotations of
+// the Queue
+func (s *Scheduler) reportNextAdmissionWindow(ctx context.Context, report admissionReport) error {
+	newQueue, err := s.QueueClient.SchedulingV1alpha1().Queues(report.namespace).Get(ctx, report.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if newQueue.Annotations[utils.AnnotationNextAdmissionWindow] == report.value {
+		return nil
+	}
+	if newQueue.Annotations == nil {
+		newQueue.Annotations = make(map[string]string)
+	}
+	newQueue.Annotations[utils.AnnotationNextAdmissionWindow] = report.value
+	_, err = s.QueueClient.SchedulingV1alpha1().Queues(report.namespace).Update(ctx, newQueue, metav1.UpdateOptions{})
+	return err
+}
+
 func (s *Scheduler) ErrorFunc(ctx context.Context, queueUnit *framework.QueueUnitInfo, q queue.SchedulingQueue) {
 	queueUnit.Attempts++
 	queueUnit.Timestamp = time.Now()
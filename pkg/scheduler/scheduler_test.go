@@ -0,0 +1,292 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/informers"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+	"github.com/kube-queue/api/pkg/client/clientset/versioned/fake"
+	"github.com/kube-queue/kube-queue/pkg/framework"
+	"github.com/kube-queue/kube-queue/pkg/framework/plugins/priority"
+	"github.com/kube-queue/kube-queue/pkg/framework/runtime"
+	"github.com/kube-queue/kube-queue/pkg/queue"
+	"github.com/kube-queue/kube-queue/pkg/queue/schedulingqueue"
+	"github.com/kube-queue/kube-queue/pkg/utils"
+)
+
+func TestGroupByQuotaScope(t *testing.T) {
+	fw, err := runtime.NewFramework(runtime.Registry{priority.Name: priority.New}, nil, "", informers.NewSharedInformerFactory(nil, 0), nil, nil)
+	if err != nil {
+		t.Fatalf("new framework failed %v", err)
+	}
+	newQueue := func(namespace, name string) queue.SchedulingQueue {
+		q := &v1alpha1.Queue{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       v1alpha1.QueueSpec{QueuePolicy: v1alpha1.QueuePolicyPriority},
+		}
+		pq, err := schedulingqueue.NewPrioritySchedulingQueue(fw, namespace+"/"+name, priority.Name, 1, 20, 30, 300, q, nil)
+		if err != nil {
+			t.Fatalf("new queue failed %v", err)
+		}
+		return pq
+	}
+	sortedQueue := []queue.SchedulingQueue{
+		newQueue("ns1", "high"),
+		newQueue("ns2", "high"),
+		newQueue("ns1", "low"),
+	}
+
+	groups := groupByQuotaScope(sortedQueue)
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 groups, got %d", len(groups))
+	}
+	if len(groups[0]) != 2 || groups[0][0] != sortedQueue[0] || groups[0][1] != sortedQueue[2] {
+		t.Errorf("expected the queues of ns1 to be grouped in order")
+	}
+	if len(groups[1]) != 1 || groups[1][0] != sortedQueue[1] {
+		t.Errorf("expected the queue of ns2 in its own group")
+	}
+
+	// a shared queue with units of ns2 is scheduled with the queues of ns2
+	shared := newQueue("ns3", "shared")
+	shared.Add(&v1alpha1.QueueUnit{
+		ObjectMeta: metav1.ObjectMeta{Name: "unit", Namespace: "ns3"},
+		Spec:       v1alpha1.QueueUnitSpec{ConsumerRef: &corev1.ObjectReference{Namespace: "ns2"}},
+	})
+	sortedQueue = append(sortedQueue, shared)
+	groups = groupByQuotaScope(sortedQueue)
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 groups, got %d", len(groups))
+	}
+	if len(groups[1]) != 2 || groups[1][0] != sortedQueue[1] || groups[1][1] != shared {
+		t.Errorf("expected the shared queue to be grouped with the queue of ns2")
+	}
+}
+
+// fakeFilterPlugin lets the units in fit pass, and records the units it filters
+type fakeFilterPlugin struct {
+	fit      sets.String
+	filtered []string
+}
+
+func (f *fakeFilterPlugin) Name() string {
+	return "Fake"
+}
+
+func (f *fakeFilterPlugin) Filter(ctx context.Context, unit *framework.QueueUnitInfo) *framework.Status {
+	f.filtered = append(f.filtered, unit.Unit.Name)
+	if f.fit.Has(unit.Unit.Name) {
+		return framework.NewStatus(framework.Success, "")
+	}
+	return framework.NewStatus(framework.Unschedulable, "")
+}
+
+// newTestScheduler creates a scheduler of a single queue with the given annotations
+// and units, the units are queued in order
+func newTestScheduler(t *testing.T, annotations map[string]string, units ...*v1alpha1.QueueUnit) (*Scheduler, queue.SchedulingQueue, *fakeFilterPlugin) {
+	plugin := &fakeFilterPlugin{fit: sets.NewString()}
+	registry := runtime.Registry{
+		priority.Name: priority.New,
+		"Fake": func(_ k8sruntime.Object, _ framework.Handle) (framework.Plugin, error) {
+			return plugin, nil
+		},
+	}
+	fw, err := runtime.NewFramework(registry, nil, "", informers.NewSharedInformerFactory(nil, 0), nil, nil)
+	if err != nil {
+		t.Fatalf("new framework failed %v", err)
+	}
+	queueObj := &v1alpha1.Queue{
+		ObjectMeta: metav1.ObjectMeta{Name: "queue", Namespace: "ns", Annotations: annotations},
+		Spec:       v1alpha1.QueueSpec{QueuePolicy: v1alpha1.QueuePolicyPriority},
+	}
+	q, err := schedulingqueue.NewPrioritySchedulingQueue(fw, "ns/queue", priority.Name, 1, 20, 30, 300, queueObj, nil)
+	if err != nil {
+		t.Fatalf("new queue failed %v", err)
+	}
+	client := fake.NewSimpleClientset(queueObj.DeepCopy())
+	for _, unit := range units {
+		if _, err := client.SchedulingV1alpha1().QueueUnits(unit.Namespace).Create(context.TODO(), unit, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("create unit %s failed %v", unit.Name, err)
+		}
+		if err := q.Add(unit); err != nil {
+			t.Fatalf("add unit %s failed %v", unit.Name, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s, err := NewScheduler(nil, fw, client, 1)
+	if err != nil {
+		t.Fatalf("new scheduler failed %v", err)
+	}
+	return s, q, plugin
+}
+
+func makeQueueUnit(name string, annotations map[string]string) *v1alpha1.QueueUnit {
+	return &v1alpha1.QueueUnit{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", Annotations: annotations},
+	}
+}
+
+func TestBlock(t *testing.T) {
+	ctx := context.TODO()
+	s, q, plugin := newTestScheduler(t, map[string]string{
+		utils.AnnotationBlocking:        "true",
+		utils.AnnotationBlockingTimeout: "1m",
+	}, makeQueueUnit("head", nil), makeQueueUnit("small", nil))
+	plugin.fit.Insert("small")
+
+	// the head which does not fit blocks the queue, the unit behind it is not tried
+	if s.scheduleQueue(ctx, ctx, q) {
+		t.Errorf("expected no unit to be scheduled behind the blocked head")
+	}
+	if len(plugin.filtered) != 1 || plugin.filtered[0] != "head" {
+		t.Errorf("expected only the head to be filtered, got %v", plugin.filtered)
+	}
+	if q.Length() != 2 {
+		t.Fatalf("expected 2 active units, got %d", q.Length())
+	}
+	head, err := q.Pop()
+	if err != nil {
+		t.Fatalf("pop failed %v", err)
+	}
+	if head.Unit.Name != "head" || head.BlockedSince.IsZero() {
+		t.Fatalf("expected the head to keep its place and be blocked, got %s blocked since %v", head.Unit.Name, head.BlockedSince)
+	}
+
+	// the head blocking the queue for longer than the timeout backs off
+	head.BlockedSince = time.Now().Add(-2 * time.Minute)
+	s.block(ctx, q, head)
+	if q.Length() != 1 {
+		t.Fatalf("expected the timed out head to leave the active queue, got %d active units", q.Length())
+	}
+	if !head.BlockedSince.IsZero() {
+		t.Errorf("expected the timed out head to be unblocked, blocked since %v", head.BlockedSince)
+	}
+	if !s.scheduleQueue(ctx, ctx, q) {
+		t.Errorf("expected the unit behind the timed out head to be scheduled")
+	}
+
+	// the blocked head which fits is unblocked
+	plugin.fit.Insert("head")
+	head.BlockedSince = time.Now()
+	if !s.dequeueIfFit(ctx, ctx, q, head) {
+		t.Fatalf("expected the head to be scheduled")
+	}
+	if !head.BlockedSince.IsZero() {
+		t.Errorf("expected the scheduled head to be unblocked, blocked since %v", head.BlockedSince)
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	ctx := context.TODO()
+	short := map[string]string{utils.AnnotationExpectedRuntime: "1m"}
+	s, q, plugin := newTestScheduler(t, map[string]string{
+		utils.AnnotationBackfill:            "true",
+		utils.AnnotationBackfillReservation: "10m",
+	}, makeQueueUnit("head", nil),
+		makeQueueUnit("short", short),
+		makeQueueUnit("long", map[string]string{utils.AnnotationExpectedRuntime: "1h"}),
+		makeQueueUnit("unknown", nil))
+	plugin.fit.Insert("short", "long", "unknown")
+
+	// only the unit expected to finish before the reservation of the head is
+	// backfilled, the head keeps its place
+	if !s.scheduleQueue(ctx, ctx, q) {
+		t.Fatalf("expected a unit to be backfilled behind the blocked head")
+	}
+	if want := []string{"head", "short"}; !equal(plugin.filtered, want) {
+		t.Errorf("expected units %v to be filtered, got %v", want, plugin.filtered)
+	}
+	if q.Length() != 3 {
+		t.Fatalf("expected 3 active units, got %d", q.Length())
+	}
+	head, err := q.Pop()
+	if err != nil {
+		t.Fatalf("pop failed %v", err)
+	}
+	if head.Unit.Name != "head" || head.BlockedSince.IsZero() {
+		t.Fatalf("expected the head to keep its place and be blocked, got %s blocked since %v", head.Unit.Name, head.BlockedSince)
+	}
+
+	// nothing is backfilled once the reservation of the head is over
+	head.BlockedSince = time.Now().Add(-time.Hour)
+	if err := q.Restore(head); err != nil {
+		t.Fatalf("restore failed %v", err)
+	}
+	plugin.filtered = nil
+	if s.scheduleQueue(ctx, ctx, q) {
+		t.Errorf("expected no unit to be backfilled after the reservation of the head")
+	}
+	if want := []string{"head"}; !equal(plugin.filtered, want) {
+		t.Errorf("expected units %v to be filtered, got %v", want, plugin.filtered)
+	}
+	if q.Length() != 3 {
+		t.Errorf("expected 3 active units, got %d", q.Length())
+	}
+}
+
+func TestReportNextAdmissionWindow(t *testing.T) {
+	ctx := context.TODO()
+	s, q, _ := newTestScheduler(t, map[string]string{
+		utils.AnnotationAdmissionWindows: "0 0 1 1 * 1s",
+	})
+	want := q.NextAdmissionTime().Format(time.RFC3339)
+
+	// the scheduling cycle only queues the report
+	s.scheduleQueue(ctx, ctx, q)
+	if s.admissionReports.Len() != 1 {
+		t.Fatalf("expected the next admission window to be queued for report, got %d reports", s.admissionReports.Len())
+	}
+	queueObj, _ := s.QueueClient.SchedulingV1alpha1().Queues("ns").Get(ctx, "queue", metav1.GetOptions{})
+	if _, ok := queueObj.Annotations[utils.AnnotationNextAdmissionWindow]; ok {
+		t.Fatalf("expected the scheduling cycle not to update the queue")
+	}
+
+	if !s.processNextAdmissionReport(ctx) {
+		t.Fatalf("expected the report to be processed")
+	}
+	queueObj, _ = s.QueueClient.SchedulingV1alpha1().Queues("ns").Get(ctx, "queue", metav1.GetOptions{})
+	if got := queueObj.Annotations[utils.AnnotationNextAdmissionWindow]; got != want {
+		t.Errorf("expected next admission window %q, got %q", want, got)
+	}
+
+	// a reported window is not reported again
+	s.updateNextAdmissionWindow(q)
+	if s.admissionReports.Len() != 0 {
+		t.Errorf("expected the reported window not to be queued again, got %d reports", s.admissionReports.Len())
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
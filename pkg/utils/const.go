@@ -20,3 +20,92 @@ const (
 	ControllerAgentName = "kube-queue-controller"
 	Default             = "default"
 )
+
+const (
+	// AnnotationHold holds a QueueUnit or pauses a whole Queue when its value is "true".
+	// Held QueueUnits stay in their queue but are never dequeued until released.
+	AnnotationHold = "scheduling.x-k8s.io/hold"
+)
+
+const (
+	// AnnotationAdmissionWindows lists the admission windows of a Queue, units of the
+	// Queue are only dequeued while one of the windows is open.
+	AnnotationAdmissionWindows = "scheduling.x-k8s.io/admission-windows"
+	// AnnotationAdmissionTimeZone is the time zone the admission windows are evaluated in,
+	// UTC is used by default.
+	AnnotationAdmissionTimeZone = "scheduling.x-k8s.io/admission-time-zone"
+	// AnnotationNextAdmissionWindow reports when the next admission window of a Queue opens.
+	AnnotationNextAdmissionWindow = "scheduling.x-k8s.io/next-admission-window"
+)
+
+const (
+	// AnnotationMaxDequeuedUnits limits the number of concurrently dequeued units of a Queue.
+	AnnotationMaxDequeuedUnits = "scheduling.x-k8s.io/max-dequeued-units"
+	// AnnotationMaxDequeuedUnitsPerUser limits the number of concurrently dequeued units of
+	// each user in a Queue.
+	AnnotationMaxDequeuedUnitsPerUser = "scheduling.x-k8s.io/max-dequeued-units-per-user"
+)
+
+const (
+	// AnnotationQueueQuota limits the resource of the dequeued units of a Queue, e.g.
+	// {"cpu": "10", "nvidia.com/gpu": "2"}.
+	AnnotationQueueQuota = "scheduling.x-k8s.io/quota"
+	// AnnotationQueueQuotaUsed reports the resource of the dequeued units of a Queue
+	// counted in its quota.
+	AnnotationQueueQuotaUsed = "scheduling.x-k8s.io/quota-used"
+)
+
+const (
+	// AnnotationCreator is the username of the creator of a QueueUnit, recorded by the
+	// admission webhook.
+	AnnotationCreator = "scheduling.x-k8s.io/creator"
+)
+
+const (
+	// AnnotationExpectedRuntime is the expected runtime of the job of a QueueUnit, e.g. "2h".
+	AnnotationExpectedRuntime = "scheduling.x-k8s.io/expected-runtime"
+	// AnnotationDeadline is the time in RFC3339 by which the job of a QueueUnit should finish.
+	AnnotationDeadline = "scheduling.x-k8s.io/deadline"
+)
+
+const (
+	// AnnotationBackfill enables backfill of a Queue when set to "true": units behind
+	// a blocked head are dequeued if they are expected to finish before the head's
+	// reservation.
+	AnnotationBackfill = "scheduling.x-k8s.io/backfill"
+	// AnnotationBackfillReservation is how long after the head of a Queue is blocked
+	// it is guaranteed to be dequeued first, e.g. "30m".
+	AnnotationBackfillReservation = "scheduling.x-k8s.io/backfill-reservation"
+)
+
+const (
+	// AnnotationBlocking enables strict ordering of a Queue when set to "true": a head
+	// that does not fit keeps its place and no unit behind it is dequeued until it fits.
+	AnnotationBlocking = "scheduling.x-k8s.io/blocking"
+	// AnnotationBlockingTimeout is how long the head of a blocking Queue blocks it at
+	// most before backing off like in other queues, e.g. "1h". No timeout by default.
+	AnnotationBlockingTimeout = "scheduling.x-k8s.io/blocking-timeout"
+)
+
+const (
+	// AnnotationSharedQueue shares a Queue with all the namespaces when set to "true",
+	// QueueUnits of other namespaces join it with spec.queue set to namespace/name.
+	AnnotationSharedQueue = "scheduling.x-k8s.io/shared"
+)
+
+const (
+	// QueueProtectionFinalizer blocks the deletion of a Queue while it has pending units.
+	QueueProtectionFinalizer = "scheduling.x-k8s.io/queue-protection"
+)
+
+const (
+	// AnnotationInitialAttemptTimestamp is the time a QueueUnit was enqueued first, in
+	// RFC3339 format. It is set when the unit is dequeued so that a requeued unit keeps
+	// its position.
+	AnnotationInitialAttemptTimestamp = "scheduling.x-k8s.io/initial-attempt-timestamp"
+	// AnnotationDequeueTimestamp is the time a QueueUnit was dequeued, in RFC3339 format.
+	AnnotationDequeueTimestamp = "scheduling.x-k8s.io/dequeue-timestamp"
+	// AnnotationStartDeadlineExpiries is the number of times the consumer of a QueueUnit
+	// did not start within the start deadline after it was dequeued.
+	AnnotationStartDeadlineExpiries = "scheduling.x-k8s.io/start-deadline-expiries"
+)
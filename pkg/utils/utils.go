@@ -0,0 +1,226 @@
+/*
+ Copyright 2021 The Kube-Queue Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kube-queue/api/pkg/apis/scheduling/v1alpha1"
+)
+
+// IsHeld checks if a QueueUnit or a Queue is held by checking whether its annotation
+// contains key AnnotationHold and its value is set "true"
+func IsHeld(obj metav1.Object) bool {
+	annotations := obj.GetAnnotations()
+	if annotations != nil {
+		if val, exist := annotations[AnnotationHold]; exist {
+			return val == "true"
+		}
+	}
+	return false
+}
+
+// IsAdmitted checks if a QueueUnit left its queue and holds its reservations
+func IsAdmitted(unit *v1alpha1.QueueUnit) bool {
+	switch unit.Status.Phase {
+	case v1alpha1.Dequeued, v1alpha1.SchedReady:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsFinished checks if the consumer of a QueueUnit finished, successfully or not, a
+// finished QueueUnit neither waits in its queue nor holds reservations
+func IsFinished(unit *v1alpha1.QueueUnit) bool {
+	switch unit.Status.Phase {
+	case v1alpha1.SchedSucceed, v1alpha1.SchedFailed:
+		return true
+	default:
+		return false
+	}
+}
+
+// ExpectedRuntime returns the expected runtime of a QueueUnit from its annotation
+// AnnotationExpectedRuntime
+func ExpectedRuntime(obj metav1.Object) (time.Duration, bool) {
+	return durationAnnotation(obj, AnnotationExpectedRuntime)
+}
+
+// Deadline returns the deadline of a QueueUnit from its annotation AnnotationDeadline
+func Deadline(obj metav1.Object) (time.Time, bool) {
+	return timeAnnotation(obj, AnnotationDeadline)
+}
+
+// InitialAttemptTimestamp returns the time a QueueUnit was enqueued first from its
+// annotation AnnotationInitialAttemptTimestamp
+func InitialAttemptTimestamp(obj metav1.Object) (time.Time, bool) {
+	return timeAnnotation(obj, AnnotationInitialAttemptTimestamp)
+}
+
+// DequeueTimestamp returns the time a QueueUnit was dequeued from its annotation
+// AnnotationDequeueTimestamp
+func DequeueTimestamp(obj metav1.Object) (time.Time, bool) {
+	return timeAnnotation(obj, AnnotationDequeueTimestamp)
+}
+
+// StartDeadlineExpiries returns the number of start deadline expiries of a QueueUnit
+// from its annotation AnnotationStartDeadlineExpiries, 0 if it is not set
+func StartDeadlineExpiries(obj metav1.Object) int {
+	n, err := strconv.Atoi(obj.GetAnnotations()[AnnotationStartDeadlineExpiries])
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+func timeAnnotation(obj metav1.Object, key string) (time.Time, bool) {
+	val, exist := obj.GetAnnotations()[key]
+	if !exist {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// IsBackfillEnabled checks if backfill is enabled for a Queue by checking whether its
+// annotation AnnotationBackfill is set "true"
+func IsBackfillEnabled(obj metav1.Object) bool {
+	return obj.GetAnnotations()[AnnotationBackfill] == "true"
+}
+
+// BackfillReservation returns the backfill reservation of a Queue from its annotation
+// AnnotationBackfillReservation
+func BackfillReservation(obj metav1.Object) (time.Duration, bool) {
+	return durationAnnotation(obj, AnnotationBackfillReservation)
+}
+
+// IsBlockingEnabled checks if a Queue blocks behind its head by checking whether its
+// annotation AnnotationBlocking is set "true"
+func IsBlockingEnabled(obj metav1.Object) bool {
+	return obj.GetAnnotations()[AnnotationBlocking] == "true"
+}
+
+// BlockingTimeout returns the blocking timeout of a Queue from its annotation
+// AnnotationBlockingTimeout
+func BlockingTimeout(obj metav1.Object) (time.Duration, bool) {
+	return durationAnnotation(obj, AnnotationBlockingTimeout)
+}
+
+// durationAnnotation parses the annotation with the given key as a non-negative duration
+func durationAnnotation(obj metav1.Object, key string) (time.Duration, bool) {
+	val, exist := obj.GetAnnotations()[key]
+	if !exist {
+		return 0, false
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil || d < 0 {
+		return 0, false
+	}
+	return d, true
+}
+
+// IsSharedQueue checks if a Queue is shared with all the namespaces by checking whether
+// its annotation AnnotationSharedQueue is set "true"
+func IsSharedQueue(obj metav1.Object) bool {
+	return obj.GetAnnotations()[AnnotationSharedQueue] == "true"
+}
+
+// QueueKey returns the key of a Queue, namespace + "/" + name
+func QueueKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// QueueUnitNamespace returns the namespace a QueueUnit consumes resources in, the
+// namespace of its consumer if set
+func QueueUnitNamespace(unit *v1alpha1.QueueUnit) string {
+	if unit.Spec.ConsumerRef != nil && unit.Spec.ConsumerRef.Namespace != "" {
+		return unit.Spec.ConsumerRef.Namespace
+	}
+	return unit.Namespace
+}
+
+// ConsumerStarted checks if one of the given pods of the consumer of a QueueUnit is
+// running or succeeded, the consumer is either the pod itself or its owner
+func ConsumerStarted(unit *v1alpha1.QueueUnit, pods []*corev1.Pod) bool {
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodRunning && pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		if IsOwnedByConsumer(pod, unit) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConsumerPods returns the given pods of the consumer of a QueueUnit which are not
+// terminated, whatever their phase
+func ConsumerPods(unit *v1alpha1.QueueUnit, pods []*corev1.Pod) []*corev1.Pod {
+	var consumerPods []*corev1.Pod
+	for _, pod := range pods {
+		if IsPodTerminated(pod) || !IsOwnedByConsumer(pod, unit) {
+			continue
+		}
+		consumerPods = append(consumerPods, pod)
+	}
+	return consumerPods
+}
+
+// IsOwnedByConsumer checks if a pod belongs to the consumer of a QueueUnit, the
+// consumer is either the pod itself or its owner
+func IsOwnedByConsumer(pod *corev1.Pod, unit *v1alpha1.QueueUnit) bool {
+	ref := unit.Spec.ConsumerRef
+	if ref == nil || pod.Namespace != QueueUnitNamespace(unit) {
+		return false
+	}
+	if ref.Kind == "Pod" && pod.Name == ref.Name {
+		return true
+	}
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == ref.Kind && owner.Name == ref.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPodTerminated checks if a pod succeeded or failed
+func IsPodTerminated(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// IsNodeSchedulable checks if new pods can be scheduled to a node, i.e. it is neither
+// cordoned nor tainted with NoSchedule or NoExecute
+func IsNodeSchedulable(node *corev1.Node) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect == corev1.TaintEffectNoSchedule || taint.Effect == corev1.TaintEffectNoExecute {
+			return false
+		}
+	}
+	return true
+}